@@ -0,0 +1,280 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/log"
+	"github.com/hashicorp/consul-template/signals"
+	"github.com/pkg/errors"
+)
+
+// unixPrefix marks a listen address as a unix socket.
+const unixPrefix = "unix:"
+
+// validateListen checks a TCP address or a unix socket prefixed with "unix:".
+func validateListen(addr string) error {
+	if strings.HasPrefix(addr, unixPrefix) {
+		if strings.TrimPrefix(addr, unixPrefix) == "" {
+			return fmt.Errorf("empty unix socket path")
+		}
+		return nil
+	}
+	_, _, err := net.SplitHostPort(addr)
+	return err
+}
+
+// listen creates a listener for a TCP address or a unix socket prefixed with "unix:".
+// A stale unix socket is removed first and the new socket is only accessible by the user of remco.
+func listen(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, unixPrefix) {
+		return net.Listen("tcp", addr)
+	}
+	path := strings.TrimPrefix(addr, unixPrefix)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "couldn't remove the old socket")
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// serveHTTP serves the handler h on the listener l in a new goroutine.
+// name is used in the log messages.
+func serveHTTP(name string, l net.Listener, h http.Handler) *http.Server {
+	srv := &http.Server{Handler: h}
+	go func() {
+		err := srv.Serve(l)
+		if err != nil && err != http.ErrServerClosed {
+			log.Error(fmt.Sprintf("error serving the %s: %v", name, err))
+		}
+	}()
+	log.WithFields("listen", l.Addr().String()).Info(name + " started")
+	return srv
+}
+
+// shutdownHTTP stops the server gracefully.
+func shutdownHTTP(srv *http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
+
+// AdminConfig configures the admin HTTP API.
+type AdminConfig struct {
+	// Listen is the TCP address, e.g. "127.0.0.1:9100", or the unix socket, e.g. "unix:/run/remco/admin.sock".
+	// The admin API is disabled if it is empty.
+	Listen string `toml:"listen"`
+}
+
+// Validate checks the listen address.
+func (c AdminConfig) Validate() error {
+	if c.Listen == "" {
+		return nil
+	}
+	return validateListen(c.Listen)
+}
+
+// adminServer serves the admin HTTP API of a Supervisor.
+type adminServer struct {
+	config     AdminConfig
+	supervisor *Supervisor
+	httpServer *http.Server
+	// controlSocket enables the endpoints that change or reveal the state of remco,
+	// they are only served on the control socket.
+	controlSocket bool
+}
+
+// newAdminServer starts the admin API on the configured listener.
+// It returns nil if the admin API is disabled.
+func newAdminServer(c AdminConfig, ru *Supervisor) (*adminServer, error) {
+	if c.Listen == "" {
+		return nil, nil
+	}
+	return startAdminServer("admin api", &adminServer{config: c, supervisor: ru})
+}
+
+// newControlServer starts the admin API on the control socket path that is used by remco ctl.
+// It returns nil if the control socket is disabled.
+func newControlServer(path string, ru *Supervisor) (*adminServer, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return startAdminServer("control socket", &adminServer{
+		config:        AdminConfig{Listen: unixPrefix + path},
+		supervisor:    ru,
+		controlSocket: true,
+	})
+}
+
+func startAdminServer(name string, s *adminServer) (*adminServer, error) {
+	l, err := listen(s.config.Listen)
+	if err != nil {
+		return nil, errors.Wrap(err, name+" listener failed")
+	}
+	s.httpServer = serveHTTP(name, l, s.handler())
+	return s, nil
+}
+
+func (s *adminServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/resources", s.listResources)
+	mux.HandleFunc("GET /v1/resources/{name}", s.getResource)
+	// the admin listener has no authentication, so it only shows the status
+	if !s.controlSocket {
+		return mux
+	}
+	mux.HandleFunc("GET /v1/resources/{name}/keys", s.getKeys)
+	mux.HandleFunc("POST /v1/reload", s.reload)
+	mux.HandleFunc("POST /v1/resources/{name}/render", s.control(func(name string, r *http.Request) error {
+		return s.supervisor.RenderResource(name)
+	}))
+	mux.HandleFunc("POST /v1/resources/{name}/pause", s.control(func(name string, r *http.Request) error {
+		return s.supervisor.PauseResource(name)
+	}))
+	mux.HandleFunc("POST /v1/resources/{name}/resume", s.control(func(name string, r *http.Request) error {
+		return s.supervisor.ResumeResource(name)
+	}))
+	mux.HandleFunc("POST /v1/resources/{name}/signal", s.control(func(name string, r *http.Request) error {
+		sig, err := signals.Parse(r.URL.Query().Get("signal"))
+		if err != nil {
+			return badRequest{err}
+		}
+		return s.supervisor.SignalResource(name, sig)
+	}))
+	return mux
+}
+
+// badRequest is an error caused by the parameters of the request.
+type badRequest struct {
+	error
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Error(fmt.Sprintf("error writing the response: %v", err))
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch err.(type) {
+	case badRequest:
+		code = http.StatusBadRequest
+	case resourceNotFound:
+		code = http.StatusNotFound
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func (s *adminServer) listResources(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.supervisor.Status())
+}
+
+func (s *adminServer) getResource(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	for _, rs := range s.supervisor.Status() {
+		if rs.Name == name {
+			writeJSON(w, http.StatusOK, rs)
+			return
+		}
+	}
+	writeError(w, resourceNotFound(name))
+}
+
+// maskedValue replaces the values that look like secrets.
+const maskedValue = "******"
+
+// secretKeyWords are the parts of the key names whose values are masked.
+var secretKeyWords = []string{"password", "passwd", "secret", "token", "credential", "private", "apikey", "api_key", "api-key", "access_key"}
+
+// maskSecret returns maskedValue if the key or the value look like a secret, e.g. /db/password or a PEM private key.
+func maskSecret(key, value string) string {
+	name := strings.ToLower(path.Base(key))
+	for _, w := range secretKeyWords {
+		if strings.Contains(name, w) {
+			return maskedValue
+		}
+	}
+	if strings.Contains(value, "PRIVATE KEY-----") {
+		return maskedValue
+	}
+	return value
+}
+
+// keyValue is a key-value pair of the keys endpoint.
+type keyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (s *adminServer) getKeys(w http.ResponseWriter, r *http.Request) {
+	data, err := s.supervisor.ResourceData(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	kvs := make([]keyValue, len(data))
+	for i, kv := range data {
+		kvs[i] = keyValue{Key: kv.Key, Value: maskSecret(kv.Key, kv.Value)}
+	}
+	writeJSON(w, http.StatusOK, kvs)
+}
+
+// reload reloads the configuration file like SIGHUP.
+// The configuration is loaded by the main function, its errors are returned.
+// The reload itself runs in the background.
+func (s *adminServer) reload(w http.ResponseWriter, r *http.Request) {
+	if err := s.supervisor.RequestReload(r.Context()); err != nil {
+		writeError(w, err)
+		return
+	}
+	log.WithFields("remote", r.RemoteAddr).Info("admin api: " + r.URL.Path)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// control returns a handler that runs the action f on the resource of the request.
+func (s *adminServer) control(f func(name string, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if err := f(name, r); err != nil {
+			writeError(w, err)
+			return
+		}
+		log.WithFields("resource", name, "remote", r.RemoteAddr).Info("admin api: " + r.URL.Path)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// Close stops the admin API.
+func (s *adminServer) Close() error {
+	if s == nil {
+		return nil
+	}
+	return shutdownHTTP(s.httpServer)
+}
@@ -9,6 +9,7 @@
 package main
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -21,80 +22,86 @@ import (
 	"github.com/HeavyHorst/remco/pkg/telemetry"
 	"github.com/HeavyHorst/remco/pkg/template"
 	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
 )
 
 // BackendConfigs holds every individually backend config.
 // The values are filled with data from the configuration file.
+// Every backend type can be configured multiple times.
 type BackendConfigs struct {
-	Etcd      *backends.EtcdConfig
-	File      *backends.FileConfig
-	Env       *backends.EnvConfig
-	Consul    *backends.ConsulConfig
-	Vault     *backends.VaultConfig
-	Redis     *backends.RedisConfig
-	Zookeeper *backends.ZookeeperConfig
-	Nats      *backends.NatsConfig
-	Mock      *backends.MockConfig
+	Etcd      []*backends.EtcdConfig
+	File      []*backends.FileConfig
+	Env       []*backends.EnvConfig
+	Consul    []*backends.ConsulConfig
+	Vault     []*backends.VaultConfig
+	Redis     []*backends.RedisConfig
+	Zookeeper []*backends.ZookeeperConfig
+	Nats      []*backends.NatsConfig
+	Mock      []*backends.MockConfig
 	Plugin    []plugin.Plugin
 }
 
+func copyConfigs[T any](configs []*T) []*T {
+	var newConfigs []*T
+	for _, c := range configs {
+		newC := new(T)
+		*newC = *c
+		newConfigs = append(newConfigs, newC)
+	}
+	return newConfigs
+}
+
+// Copy returns a deep copy of the BackendConfigs.
 func (c *BackendConfigs) Copy() BackendConfigs {
-	newC := BackendConfigs{}
-	if c.Etcd != nil {
-		newC.Etcd = new(backends.EtcdConfig)
-		*newC.Etcd = *c.Etcd
+	newC := BackendConfigs{
+		Etcd:      copyConfigs(c.Etcd),
+		File:      copyConfigs(c.File),
+		Env:       copyConfigs(c.Env),
+		Consul:    copyConfigs(c.Consul),
+		Vault:     copyConfigs(c.Vault),
+		Redis:     copyConfigs(c.Redis),
+		Zookeeper: copyConfigs(c.Zookeeper),
+		Nats:      copyConfigs(c.Nats),
+		Mock:      copyConfigs(c.Mock),
 	}
-	if c.File != nil {
-		newC.File = new(backends.FileConfig)
-		*newC.File = *c.File
+	if c.Plugin != nil {
+		newC.Plugin = append([]plugin.Plugin(nil), c.Plugin...)
 	}
-	if c.Env != nil {
-		newC.Env = new(backends.EnvConfig)
-		*newC.Env = *c.Env
+	return newC
+}
+
+// GetBackends returns a slice with all BackendConfigs for easy iteration.
+func (c *BackendConfigs) GetBackends() []template.BackendConnector {
+	var bc []template.BackendConnector
+	for _, v := range c.Etcd {
+		bc = append(bc, v)
 	}
-	if c.Consul != nil {
-		newC.Consul = new(backends.ConsulConfig)
-		*newC.Consul = *c.Consul
+	for _, v := range c.File {
+		bc = append(bc, v)
 	}
-	if c.Vault != nil {
-		newC.Vault = new(backends.VaultConfig)
-		*newC.Vault = *c.Vault
+	for _, v := range c.Env {
+		bc = append(bc, v)
 	}
-	if c.Redis != nil {
-		newC.Redis = new(backends.RedisConfig)
-		*newC.Redis = *c.Redis
+	for _, v := range c.Consul {
+		bc = append(bc, v)
 	}
-	if c.Zookeeper != nil {
-		newC.Zookeeper = new(backends.ZookeeperConfig)
-		*newC.Zookeeper = *c.Zookeeper
+	for _, v := range c.Vault {
+		bc = append(bc, v)
 	}
-	if c.Nats != nil {
-		newC.Nats = new(backends.NatsConfig)
-		*newC.Nats = *c.Nats
+	for _, v := range c.Redis {
+		bc = append(bc, v)
 	}
-	if c.Mock != nil {
-		newC.Mock = new(backends.MockConfig)
-		*newC.Mock = *c.Mock
+	for _, v := range c.Zookeeper {
+		bc = append(bc, v)
 	}
-	return newC
-}
-
-// GetBackends returns a slice with all BackendConfigs for easy iteration.
-func (c *BackendConfigs) GetBackends() []template.BackendConnector {
-	bc := []template.BackendConnector{
-		c.Etcd,
-		c.File,
-		c.Env,
-		c.Consul,
-		c.Vault,
-		c.Redis,
-		c.Zookeeper,
-		c.Mock,
-		c.Nats,
+	for _, v := range c.Mock {
+		bc = append(bc, v)
 	}
-
-	for _, v := range c.Plugin {
-		bc = append(bc, &v)
+	for _, v := range c.Nats {
+		bc = append(bc, v)
+	}
+	for i := range c.Plugin {
+		bc = append(bc, &c.Plugin[i])
 	}
 
 	return bc
@@ -110,11 +117,22 @@ type Configuration struct {
 	LogFile    string `toml:"log_file"`
 	Resource   []Resource
 	Telemetry  telemetry.Telemetry
-}
 
-type DefaultBackends struct {
-	Backends BackendConfigs `toml:"default_backends"`
-	Resource []Resource
+	// Admin configures the admin HTTP API.
+	Admin AdminConfig
+
+	// Health configures the /healthz and /readyz endpoints.
+	Health HealthConfig
+
+	// ControlSocket is the path of the unix socket that is used by remco ctl.
+	// The control socket is disabled if it is empty.
+	ControlSocket string `toml:"control_socket"`
+
+	// WatchConfig enables the automatic reload on changes of the configuration file,
+	// the include_dir and the filter_dir.
+	WatchConfig bool `toml:"watch_config"`
+	// WatchConfigDebounce is the time to wait for further changes before the configuration is reloaded.
+	WatchConfigDebounce string `toml:"watch_config_debounce"`
 }
 
 // Resource is the representation of an resource configuration
@@ -125,10 +143,41 @@ type Resource struct {
 	Template  []*template.Renderer
 	Backends  BackendConfigs `toml:"backend"`
 
+	// KeyCollision decides which value is used if multiple backends hold the same key.
+	KeyCollision template.KeyCollisionPolicy `toml:"key_collision" json:"key_collision"`
+
+	// Wait configures the quiescence timers, e.g. wait = { min = "2s", max = "10s" }.
+	Wait template.WaitConfig `json:"wait"`
+
+	// DryRun prints a diff instead of writing the templates.
+	DryRun bool `toml:"dry_run" json:"dry_run"`
+
+	// RollbackOnReloadFailure restores the previous templates if a reload command fails.
+	RollbackOnReloadFailure bool `toml:"rollback_on_reload_failure" json:"rollback_on_reload_failure"`
+
+	// Cache configures the on-disk cache of the backend data.
+	Cache template.CacheConfig `json:"cache"`
+
+	// OnFailure decides what happens if the resource failed MaxFailures times within FailureWindow:
+	// retry, exit or ignore.
+	OnFailure     template.FailureAction `toml:"on_failure" json:"on_failure"`
+	MaxFailures   int                    `toml:"max_failures" json:"max_failures"`
+	FailureWindow string                 `toml:"failure_window" json:"failure_window"`
+
 	// defaults to the filename of the resource
 	Name string
 }
 
+// failurePolicy returns the failure policy of the resource.
+func (r Resource) failurePolicy() template.FailurePolicy {
+	return template.FailurePolicy{OnFailure: r.OnFailure, MaxFailures: r.MaxFailures, Window: r.FailureWindow}
+}
+
+// empty reports whether the resource has neither templates nor a child process.
+func (r Resource) empty() bool {
+	return len(r.Template) == 0 && r.Exec.Command == ""
+}
+
 func readFileAndExpandEnv(path string) ([]byte, error) {
 	buf, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -139,33 +188,224 @@ func readFileAndExpandEnv(path string) ([]byte, error) {
 	return buf, nil
 }
 
+// isConfigFile reports whether the file extension belongs to a supported configuration format.
+func isConfigFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml", ".yml", ".yaml", ".json":
+		return true
+	}
+	return false
+}
+
+// parseConfig parses buf into a generic map.
+// The format is chosen by the file extension of path: .yml and .yaml files are parsed as yaml,
+// .json files as json and all other files as toml.
+// The backend tables are normalized, see normalizeBackends.
+func parseConfig(path string, buf []byte) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml", ".json":
+		// json is a subset of yaml, so the yaml decoder handles both
+		var doc yaml.Node
+		if err := yaml.Unmarshal(buf, &doc); err != nil {
+			return nil, errors.Wrapf(err, "yaml unmarshal failed: %s", path)
+		}
+		if doc.Kind != 0 {
+			quoteModes(&doc)
+			if err := doc.Decode(&data); err != nil {
+				return nil, errors.Wrapf(err, "yaml unmarshal failed: %s", path)
+			}
+		}
+		if data == nil {
+			data = make(map[string]interface{})
+		}
+	default:
+		if _, err := toml.Decode(string(buf), &data); err != nil {
+			return nil, errors.Wrapf(err, "toml unmarshal failed: %s", path)
+		}
+	}
+
+	if b, ok := data["default_backends"].(map[string]interface{}); ok {
+		normalizeBackends(b)
+	}
+	if b, ok := data["backend"].(map[string]interface{}); ok {
+		normalizeBackends(b)
+	}
+	for _, r := range tables(data["resource"]) {
+		if b, ok := r["backend"].(map[string]interface{}); ok {
+			normalizeBackends(b)
+		}
+	}
+	return data, nil
+}
+
+// decodeConfig decodes the parsed configuration data into v.
+// The data is converted to toml first, so that every format shares the same keys.
+// It returns the toml metadata and an error if any.
+func decodeConfig(path string, data map[string]interface{}, v interface{}) (toml.MetaData, error) {
+	var b bytes.Buffer
+	if err := toml.NewEncoder(&b).Encode(data); err != nil {
+		return toml.MetaData{}, errors.Wrapf(err, "toml encode failed: %s", path)
+	}
+	md, err := toml.Decode(b.String(), v)
+	if err != nil {
+		return md, errors.Wrapf(err, "toml unmarshal failed: %s", path)
+	}
+	return md, nil
+}
+
+// tables returns all tables of a toml array of tables or a yaml/json list of objects.
+func tables(v interface{}) []map[string]interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{v}
+	case []map[string]interface{}:
+		return v
+	case []interface{}:
+		var t []map[string]interface{}
+		for _, e := range v {
+			if m, ok := e.(map[string]interface{}); ok {
+				t = append(t, m)
+			}
+		}
+		return t
+	}
+	return nil
+}
+
+// quoteModes keeps the numeric mode values as they are written, e.g. mode: 0644.
+// yaml would parse them as integers, but the mode is a string.
+func quoteModes(n *yaml.Node) {
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if k.Value == "mode" && v.Kind == yaml.ScalarNode && v.Tag == "!!int" {
+				v.Tag = "!!str"
+			}
+		}
+	}
+	for _, c := range n.Content {
+		quoteModes(c)
+	}
+}
+
+// normalizeBackends converts every backend table to an array of tables.
+// This allows both the single table syntax [resource.backend.etcd]
+// and the array of tables syntax [[resource.backend.etcd]].
+func normalizeBackends(b map[string]interface{}) {
+	for k, v := range b {
+		if t := tables(v); t != nil {
+			b[k] = t
+		}
+	}
+}
+
+// mergeTables returns a new table with all values of defaults overwritten by the values of t.
+// Nested tables are merged recursively.
+func mergeTables(defaults, t map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(defaults)+len(t))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range t {
+		dm, ok1 := merged[k].(map[string]interface{})
+		m, ok2 := v.(map[string]interface{})
+		if ok1 && ok2 {
+			merged[k] = mergeTables(dm, m)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// applyDefaultBackends merges the (normalized) default backends into the backend table of the resource r.
+//
+// Backend types that are not configured in the resource are copied from the defaults.
+// Otherwise every configured backend is merged with the default backend of the same type
+// at the same position, or with the first default backend of that type if there is none at that position.
+func applyDefaultBackends(r, defaults map[string]interface{}) {
+	if len(defaults) == 0 {
+		return
+	}
+	b, ok := r["backend"].(map[string]interface{})
+	if !ok {
+		b = make(map[string]interface{})
+		r["backend"] = b
+	}
+	for typ, v := range defaults {
+		d := tables(v)
+		if len(d) == 0 {
+			continue
+		}
+		configured := tables(b[typ])
+		if len(configured) == 0 {
+			b[typ] = d
+			continue
+		}
+		merged := make([]map[string]interface{}, len(configured))
+		for i, t := range configured {
+			if i < len(d) {
+				merged[i] = mergeTables(d[i], t)
+			} else {
+				merged[i] = mergeTables(d[0], t)
+			}
+		}
+		b[typ] = merged
+	}
+}
+
+// readConfig reads the main configuration file at path and expands the environment variables.
+// The default_backends are merged into every resource and returned for the include_dir resources.
+func readConfig(path string) (map[string]interface{}, map[string]interface{}, error) {
+	buf, err := readFileAndExpandEnv(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := parseConfig(path, buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	defaults, _ := data["default_backends"].(map[string]interface{})
+	for _, r := range tables(data["resource"]) {
+		applyDefaultBackends(r, defaults)
+	}
+	return data, defaults, nil
+}
+
+// readResource reads the resource configuration file at path and expands the environment variables.
+// The default backends are merged into the resource.
+func readResource(path string, defaults map[string]interface{}) (map[string]interface{}, error) {
+	buf, err := readFileAndExpandEnv(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := parseConfig(path, buf)
+	if err != nil {
+		return nil, err
+	}
+	applyDefaultBackends(data, defaults)
+	return data, nil
+}
+
 // NewConfiguration reads the file at `path`, expand the environment variables
 // and unmarshals it to a new configuration struct.
+// The file can be written in toml, yaml or json, see parseConfig.
 // It returns an error if any.
 func NewConfiguration(path string) (Configuration, error) {
 	var c Configuration
-	var dbc DefaultBackends
 
-	buf, err := readFileAndExpandEnv(path)
+	data, defaults, err := readConfig(path)
 	if err != nil {
 		return c, err
 	}
 
-	if err := toml.Unmarshal(buf, &dbc); err != nil {
-		return c, errors.Wrapf(err, "toml unmarshal failed: %s", path)
-	}
-
-	c.Resource = dbc.Resource
-	for i := 0; i < len(c.Resource); i++ {
-		c.Resource[i].Backends = dbc.Backends.Copy()
-	}
-
 	// Set defaults as in go-metrics DefaultConfig
 	c.Telemetry.EnableHostname = true
 	c.Telemetry.EnableRuntimeMetrics = true
 
-	if err := toml.Unmarshal(buf, &c); err != nil {
-		return c, errors.Wrapf(err, "toml unmarshal failed: %s", path)
+	if _, err := decodeConfig(path, data, &c); err != nil {
+		return c, err
 	}
 
 	for _, v := range c.Resource {
@@ -180,25 +420,23 @@ func NewConfiguration(path string) (Configuration, error) {
 			return c, err
 		}
 		for _, file := range files {
-			if strings.HasSuffix(file.Name(), ".toml") {
+			if isConfigFile(file.Name()) {
 				fp := filepath.Join(c.IncludeDir, file.Name())
 
 				log.WithFields(
 					"path", fp,
 				).Info("loading resource configuration")
 
-				buf, err := readFileAndExpandEnv(fp)
+				data, err := readResource(fp, defaults)
 				if err != nil {
 					return c, err
 				}
-				r := Resource{
-					Backends: dbc.Backends.Copy(),
-				}
-				if err := toml.Unmarshal(buf, &r); err != nil {
-					return c, errors.Wrapf(err, "toml unmarshal failed: %s", fp)
+				var r Resource
+				if _, err := decodeConfig(fp, data, &r); err != nil {
+					return c, err
 				}
 				// don't add empty resources
-				if len(r.Template) > 0 {
+				if !r.empty() {
 					if r.Name == "" {
 						r.Name = file.Name()
 					}
@@ -72,6 +72,64 @@ const (
 `
 )
 
+const (
+	yamlTestFile string = `
+log_level: debug
+log_format: text
+include_dir: /tmp/resource-yaml.d/
+default_backends:
+  mock:
+    onetime: false
+    prefix: Hallo
+resource:
+  - name: haproxy
+    template:
+      - src: /tmp/test12345.tmpl
+        dst: /tmp/test12345.cfg
+        mode: "0644"
+    backend:
+      mock:
+        keys: ["/"]
+        watchKeys: ["/"]
+        watch: false
+        interval: 1
+`
+	jsonResourceFile string = `{
+  "template": [
+    {"src": "/tmp/test12345.tmpl", "dst": "/tmp/test12345.cfg", "mode": "0644"}
+  ],
+  "backend": {
+    "mock": {"keys": ["/"], "watchKeys": ["/"], "watch": false, "interval": 1}
+  }
+}`
+)
+
+const multiBackendFile string = `
+[default_backends]
+  [default_backends.mock]
+    prefix = "Hallo"
+    interval = 5
+
+[[resource]]
+  name = "multi"
+  [[resource.template]]
+    src = "/tmp/test12345.tmpl"
+    dst = "/tmp/test12345.cfg"
+  [[resource.backend.mock]]
+    name = "first"
+    keys = ["/a"]
+  [[resource.backend.mock]]
+    name = "second"
+    keys = ["/b"]
+    interval = 10
+
+[[resource]]
+  name = "defaults"
+  [[resource.template]]
+    src = "/tmp/test12345.tmpl"
+    dst = "/tmp/test12345.cfg"
+`
+
 var expectedTemplates = []*template.Renderer{
 	{
 		Src:  "/tmp/test12345.tmpl",
@@ -81,7 +139,7 @@ var expectedTemplates = []*template.Renderer{
 }
 
 var expectedBackend = BackendConfigs{
-	Mock: &backends.MockConfig{
+	Mock: []*backends.MockConfig{{
 		Backend: template.Backend{
 			Watch:     false,
 			Keys:      []string{"/"},
@@ -90,7 +148,7 @@ var expectedBackend = BackendConfigs{
 			Onetime:   false,
 			Prefix:    "Hallo",
 		},
-	},
+	}},
 }
 
 var expected = Configuration{
@@ -175,3 +233,71 @@ func (s *FilterSuite) TestNewConf(t *C) {
 	}
 	t.Check(cfg, DeepEquals, expected)
 }
+
+func (s *FilterSuite) TestNewConfYAMLAndJSON(t *C) {
+	err := os.Mkdir("/tmp/resource-yaml.d", 0755)
+	t.Assert(err, IsNil)
+	defer os.RemoveAll("/tmp/resource-yaml.d")
+
+	err = ioutil.WriteFile("/tmp/resource-yaml.d/test.json", []byte(jsonResourceFile), 0644)
+	t.Assert(err, IsNil)
+
+	cfgPath := "/tmp/remco-test-config.yaml"
+	err = ioutil.WriteFile(cfgPath, []byte(yamlTestFile), 0644)
+	t.Assert(err, IsNil)
+	defer os.Remove(cfgPath)
+
+	cfg, err := NewConfiguration(cfgPath)
+	t.Assert(err, IsNil)
+	t.Check(cfg.IncludeDir, Equals, "/tmp/resource-yaml.d/")
+	t.Assert(cfg.Resource, HasLen, 2)
+	t.Check(cfg.Resource[0].Name, Equals, "haproxy")
+	t.Check(cfg.Resource[0].Template, DeepEquals, expectedTemplates)
+	t.Check(cfg.Resource[0].Backends, DeepEquals, expectedBackend)
+	t.Check(cfg.Resource[1].Name, Equals, "test.json")
+	t.Check(cfg.Resource[1].Template, DeepEquals, expectedTemplates)
+	t.Check(cfg.Resource[1].Backends, DeepEquals, expectedBackend)
+}
+
+func (s *FilterSuite) TestParseConfigYAMLMode(t *C) {
+	data, err := parseConfig("config.yml", []byte("resource:\n  - template:\n      - src: a.tmpl\n        dst: a.cfg\n        mode: 0644\n"))
+	t.Assert(err, IsNil)
+	var cfg Configuration
+	_, err = decodeConfig("config.yml", data, &cfg)
+	t.Assert(err, IsNil)
+	t.Assert(cfg.Resource, HasLen, 1)
+	t.Check(cfg.Resource[0].Template[0].Mode, Equals, "0644")
+}
+
+func (s *FilterSuite) TestNewConfMultipleBackends(t *C) {
+	f, err := ioutil.TempFile("/tmp", "*.toml")
+	t.Assert(err, IsNil)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(multiBackendFile)
+	f.Close()
+	t.Assert(err, IsNil)
+
+	cfg, err := NewConfiguration(f.Name())
+	t.Assert(err, IsNil)
+	t.Assert(cfg.Resource, HasLen, 2)
+
+	// every entry is merged with the defaults
+	t.Check(cfg.Resource[0].Backends.Mock, DeepEquals, []*backends.MockConfig{
+		{Backend: template.Backend{Name: "first", Keys: []string{"/a"}, Prefix: "Hallo", Interval: 5}},
+		{Backend: template.Backend{Name: "second", Keys: []string{"/b"}, Prefix: "Hallo", Interval: 10}},
+	})
+	t.Check(cfg.Resource[0].Backends.GetBackends(), HasLen, 2)
+
+	// resources without their own backend get a copy of the defaults
+	t.Check(cfg.Resource[1].Backends.Mock, DeepEquals, []*backends.MockConfig{
+		{Backend: template.Backend{Prefix: "Hallo", Interval: 5}},
+	})
+	t.Check(cfg.Resource[1].Backends.Mock[0], Not(Equals), cfg.Resource[0].Backends.Mock[0])
+}
+
+func (s *FilterSuite) TestBackendConfigsCopy(t *C) {
+	c := expectedBackend.Copy()
+	t.Check(c, DeepEquals, expectedBackend)
+	c.Mock[0].Prefix = "changed"
+	t.Check(expectedBackend.Mock[0].Prefix, Equals, "Hallo")
+}
@@ -0,0 +1,177 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ctlTimeout is the timeout of a request to the control socket.
+const ctlTimeout = 30 * time.Second
+
+// ctlClient sends requests to the control socket of a running remco.
+type ctlClient struct {
+	client http.Client
+}
+
+func newCtlClient(socket string) *ctlClient {
+	return &ctlClient{client: http.Client{
+		Timeout: ctlTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}}
+}
+
+// do sends a request to the admin API and decodes the response into v.
+// The error of the response is returned if the request failed.
+func (c *ctlClient) do(method, path string, v interface{}) error {
+	req, err := http.NewRequest(method, "http://remco"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("request failed: %s", resp.Status)
+		}
+		return errors.New(e.Error)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// status prints the status of all resources.
+func (c *ctlClient) status(w io.Writer) error {
+	var status []ResourceStatus
+	if err := c.do("GET", "/v1/resources", &status); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATE\tREADY\tPID\tRESTARTS\tLAST RENDER\tLAST ERROR")
+	for _, rs := range status {
+		lastRender := "-"
+		if !rs.LastRender.IsZero() {
+			lastRender = rs.LastRender.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%d\t%d\t%s\t%s\n", rs.Name, rs.State, rs.Ready, rs.PID, rs.Restarts, lastRender, rs.LastError)
+	}
+	return tw.Flush()
+}
+
+// keys prints the key-value pairs of the resource name, secrets are masked by remco.
+func (c *ctlClient) keys(w io.Writer, name string) error {
+	var kvs []keyValue
+	if err := c.do("GET", "/v1/resources/"+url.PathEscape(name)+"/keys", &kvs); err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		fmt.Fprintf(w, "%s = %s\n", kv.Key, kv.Value)
+	}
+	return nil
+}
+
+// control runs the action on the resource name, e.g. render or pause.
+func (c *ctlClient) control(name, action string, query url.Values) error {
+	path := "/v1/resources/" + url.PathEscape(name) + "/" + action
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return c.do("POST", path, nil)
+}
+
+// ctl runs the command with its arguments and returns false if they are invalid.
+func (c *ctlClient) ctl(w io.Writer, args []string) (bool, error) {
+	switch {
+	case len(args) == 1 && args[0] == "status":
+		return true, c.status(w)
+	case len(args) == 1 && args[0] == "reload":
+		return true, c.do("POST", "/v1/reload", nil)
+	case len(args) == 2 && args[0] == "keys":
+		return true, c.keys(w, args[1])
+	case len(args) == 2 && (args[0] == "render" || args[0] == "pause" || args[0] == "resume"):
+		return true, c.control(args[1], args[0], nil)
+	case len(args) == 3 && args[0] == "signal":
+		return true, c.control(args[1], "signal", url.Values{"signal": {args[2]}})
+	}
+	return false, nil
+}
+
+func runCtl(args []string) int {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	path := fs.String("config", defaultConfig, "path to the configuration file, used to find the control socket")
+	socket := fs.String("socket", "", "path to the control socket, overrides control_socket of the configuration")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: remco ctl [-config path] [-socket path] <command>
+
+Commands:
+  status                     print the status of all resources
+  reload                     reload the configuration
+  render <resource>          render the templates of the resource
+  pause <resource>           stop rendering the changes of the backends
+  resume <resource>          render the collected changes and continue
+  signal <resource> <signal> send the signal to the child process of the resource
+  keys <resource>            print the current data of the resource, secrets are masked
+
+`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	if *socket == "" {
+		cfg, err := NewConfiguration(*path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, errors.Wrap(err, "failed to read config"))
+			return 1
+		}
+		if cfg.ControlSocket == "" {
+			fmt.Fprintf(os.Stderr, "no control_socket is configured in %s\n", *path)
+			return 1
+		}
+		*socket = cfg.ControlSocket
+	}
+
+	ok, err := newCtlClient(*socket).ctl(os.Stdout, fs.Args())
+	if !ok {
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	. "gopkg.in/check.v1"
+)
+
+type CtlTestSuite struct {
+	runner  *Supervisor
+	control *adminServer
+	client  *ctlClient
+}
+
+var _ = Suite(&CtlTestSuite{})
+
+func (s *CtlTestSuite) SetUpSuite(t *C) {
+	s.runner = &Supervisor{
+		resources:      map[string]*runningResource{"starting": {key: "starting"}},
+		reloadRequests: make(chan chan<- error),
+	}
+	socket := filepath.Join(t.MkDir(), "remco.sock")
+	control, err := newControlServer(socket, s.runner)
+	t.Assert(err, IsNil)
+	s.control = control
+	s.client = newCtlClient(socket)
+}
+
+func (s *CtlTestSuite) TearDownSuite(t *C) {
+	s.control.Close()
+}
+
+func (s *CtlTestSuite) ctl(args ...string) (string, bool, error) {
+	var out bytes.Buffer
+	ok, err := s.client.ctl(&out, args)
+	return out.String(), ok, err
+}
+
+func (s *CtlTestSuite) TestStatus(t *C) {
+	out, ok, err := s.ctl("status")
+	t.Assert(ok, Equals, true)
+	t.Assert(err, IsNil)
+	t.Check(out, Matches, "NAME +STATE +READY.*\nstarting +starting +false +0 +0 +- +\n")
+}
+
+func (s *CtlTestSuite) TestErrors(t *C) {
+	_, ok, err := s.ctl("render", "starting")
+	t.Check(ok, Equals, true)
+	t.Check(err, ErrorMatches, `resource "starting" is not started yet`)
+
+	_, _, err = s.ctl("keys", "missing")
+	t.Check(err, ErrorMatches, `resource "missing" not found`)
+
+	_, _, err = s.ctl("signal", "starting", "SIGFOO")
+	t.Check(err, NotNil)
+
+	for _, args := range [][]string{{"status", "foo"}, {"render"}, {"signal", "starting"}, {"foo"}} {
+		_, ok, _ = s.ctl(args...)
+		t.Check(ok, Equals, false, Commentf("%v", args))
+	}
+}
+
+func (s *CtlTestSuite) TestReload(t *C) {
+	// the main function loads the configuration and replies to the reload request
+	go func() {
+		reply := <-s.runner.ReloadRequests()
+		reply <- errors.New("failed to read config: broken")
+		reply = <-s.runner.ReloadRequests()
+		reply <- nil
+	}()
+	_, _, err := s.ctl("reload")
+	t.Check(err, ErrorMatches, "failed to read config: broken")
+	_, _, err = s.ctl("reload")
+	t.Check(err, IsNil)
+}
@@ -0,0 +1,202 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/telemetry"
+	"github.com/HeavyHorst/remco/pkg/template"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultBackendFailureThreshold = 5 * time.Minute
+	defaultUnhealthyFailures       = 5
+)
+
+// HealthConfig configures the /healthz and /readyz endpoints.
+type HealthConfig struct {
+	// Listen is the TCP address or the unix socket of the health endpoints.
+	Listen string `toml:"listen"`
+
+	// Prometheus serves the health endpoints on the listener of the prometheus sink.
+	Prometheus bool `toml:"prometheus"`
+
+	// BackendFailureThreshold is the time a backend may fail before remco isn't live anymore. Defaults to 5m.
+	BackendFailureThreshold string `toml:"backend_failure_threshold"`
+
+	// UnhealthyFailures is the number of failures of a resource or its child process within the UnhealthyWindow
+	// after which remco isn't live anymore. Defaults to 5 failures in 10m.
+	// The names differ from max_failures and failure_window of a resource, which decide about its on_failure action.
+	UnhealthyFailures int    `toml:"unhealthy_failures"`
+	UnhealthyWindow   string `toml:"unhealthy_window"`
+}
+
+// enabled reports whether the health endpoints are served.
+func (c HealthConfig) enabled() bool {
+	return c.Listen != "" || c.Prometheus
+}
+
+func parseDurationDefault(s string, d time.Duration) (time.Duration, error) {
+	if s == "" {
+		return d, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// thresholds returns the backend failure threshold and the window of the resource failures.
+func (c HealthConfig) thresholds() (time.Duration, time.Duration, error) {
+	backend, err := parseDurationDefault(c.BackendFailureThreshold, defaultBackendFailureThreshold)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "backend_failure_threshold")
+	}
+	window, err := parseDurationDefault(c.UnhealthyWindow, template.DefaultFailureWindow)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "unhealthy_window")
+	}
+	return backend, window, nil
+}
+
+func (c HealthConfig) unhealthyFailures() int {
+	if c.UnhealthyFailures <= 0 {
+		return defaultUnhealthyFailures
+	}
+	return c.UnhealthyFailures
+}
+
+// Validate checks the health configuration.
+func (c HealthConfig) Validate() []configProblem {
+	var problems []configProblem
+	if c.Listen != "" {
+		if err := validateListen(c.Listen); err != nil {
+			problems = append(problems, configProblem{Key: "health.listen", Err: err})
+		}
+	}
+	if _, err := parseDurationDefault(c.BackendFailureThreshold, 0); err != nil {
+		problems = append(problems, configProblem{Key: "health.backend_failure_threshold", Err: err})
+	}
+	if _, err := parseDurationDefault(c.UnhealthyWindow, 0); err != nil {
+		problems = append(problems, configProblem{Key: "health.unhealthy_window", Err: err})
+	}
+	if c.UnhealthyFailures < 0 {
+		problems = append(problems, configProblem{Key: "health.unhealthy_failures", Err: fmt.Errorf("must not be negative")})
+	}
+	return problems
+}
+
+// healthServer serves the health endpoints of a Supervisor.
+type healthServer struct {
+	config     HealthConfig
+	supervisor *Supervisor
+	httpServer *http.Server
+}
+
+// newHealthServer creates the health endpoints and starts the listener if configured.
+// The endpoints are added to the prometheus sink of t if configured.
+// It returns nil if the health endpoints are disabled.
+func newHealthServer(c HealthConfig, t telemetry.Telemetry, ru *Supervisor) (*healthServer, error) {
+	if !c.enabled() {
+		return nil, nil
+	}
+	if _, _, err := c.thresholds(); err != nil {
+		return nil, err
+	}
+	s := &healthServer{config: c, supervisor: ru}
+	if c.Prometheus {
+		if t.Sinks.Prometheus == nil {
+			return nil, fmt.Errorf("the health endpoints require a prometheus sink")
+		}
+		t.Sinks.Prometheus.Handlers = map[string]http.Handler{
+			"/healthz": http.HandlerFunc(s.healthz),
+			"/readyz":  http.HandlerFunc(s.readyz),
+		}
+	}
+	if c.Listen != "" {
+		l, err := listen(c.Listen)
+		if err != nil {
+			return nil, errors.Wrap(err, "health listener failed")
+		}
+		s.httpServer = serveHTTP("health endpoint", l, s.handler())
+	}
+	return s, nil
+}
+
+func (s *healthServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+	return mux
+}
+
+// writeHealth writes the result of a health check.
+// The status code is 200 if there are no problems and 503 otherwise.
+func writeHealth(w http.ResponseWriter, problems []string) {
+	if len(problems) == 0 {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "failing", "problems": problems})
+}
+
+func (s *healthServer) healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, s.live(time.Now()))
+}
+
+func (s *healthServer) readyz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, s.ready())
+}
+
+// ready returns the resources that are not ready.
+// A resource is ready once it left the initial retry loop, rendered the templates and spawned its child process.
+// Remco is not ready without any running resource, e.g. before the first resource was started or after all exited.
+func (s *healthServer) ready() []string {
+	status := s.supervisor.Status()
+	if len(status) == 0 {
+		return []string{"no resource is running"}
+	}
+	var problems []string
+	for _, rs := range status {
+		if !rs.Ready {
+			problems = append(problems, fmt.Sprintf("resource %s is not ready (%s)", rs.Name, rs.State))
+		}
+	}
+	return problems
+}
+
+// live returns the reasons why remco is not live anymore:
+// a resource that fails too often or a backend that fails longer than the threshold.
+func (s *healthServer) live(now time.Time) []string {
+	backendThreshold, window, _ := s.config.thresholds()
+	maxFailures := s.config.unhealthyFailures()
+
+	var problems []string
+	failures := s.supervisor.resourceFailures(now.Add(-window))
+	for _, rs := range s.supervisor.Status() {
+		if n := failures[rs.Name]; n >= maxFailures {
+			problems = append(problems, fmt.Sprintf("resource %s failed %d times in %s", rs.Name, n, window))
+		}
+		for _, b := range rs.Backends {
+			if !b.FailingSince.IsZero() && now.Sub(b.FailingSince) > backendThreshold {
+				problems = append(problems, fmt.Sprintf("backend %s of resource %s is failing since %s", b.Name, rs.Name, b.FailingSince.Format(time.RFC3339)))
+			}
+		}
+	}
+	return problems
+}
+
+// Close stops the listener of the health endpoints.
+func (s *healthServer) Close() error {
+	if s == nil || s.httpServer == nil {
+		return nil
+	}
+	return shutdownHTTP(s.httpServer)
+}
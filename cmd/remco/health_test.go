@@ -0,0 +1,70 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/telemetry"
+
+	. "gopkg.in/check.v1"
+)
+
+type HealthTestSuite struct{}
+
+var _ = Suite(&HealthTestSuite{})
+
+func (s *HealthTestSuite) TestEndpoints(t *C) {
+	ru := &Supervisor{resources: map[string]*runningResource{}}
+	health, err := newHealthServer(HealthConfig{Listen: "127.0.0.1:0"}, telemetry.Telemetry{}, ru)
+	t.Assert(err, IsNil)
+	defer health.Close()
+
+	request := func(url string) int {
+		w := httptest.NewRecorder()
+		health.handler().ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w.Code
+	}
+	// without any resource remco is live, but not ready
+	t.Check(request("/readyz"), Equals, http.StatusServiceUnavailable)
+	t.Check(health.ready(), DeepEquals, []string{"no resource is running"})
+	t.Check(request("/healthz"), Equals, http.StatusOK)
+
+	// a resource that isn't created yet is not ready, but live
+	ru.resources["starting"] = &runningResource{key: "starting"}
+	t.Check(request("/readyz"), Equals, http.StatusServiceUnavailable)
+	t.Check(health.ready(), DeepEquals, []string{"resource starting is not ready (starting)"})
+	t.Check(request("/healthz"), Equals, http.StatusOK)
+	t.Check(health.live(time.Now()), HasLen, 0)
+}
+
+func (s *HealthTestSuite) TestPrometheus(t *C) {
+	ru := &Supervisor{}
+	_, err := newHealthServer(HealthConfig{Prometheus: true}, telemetry.Telemetry{}, ru)
+	t.Check(err, ErrorMatches, ".*require a prometheus sink")
+
+	prometheus := &telemetry.PrometheusSink{}
+	health, err := newHealthServer(HealthConfig{Prometheus: true}, telemetry.Telemetry{Sinks: telemetry.Sinks{Prometheus: prometheus}}, ru)
+	t.Assert(err, IsNil)
+	t.Check(health.httpServer, IsNil)
+	t.Check(prometheus.Handlers, HasLen, 2)
+
+	health, err = newHealthServer(HealthConfig{}, telemetry.Telemetry{}, ru)
+	t.Check(err, IsNil)
+	t.Check(health, IsNil)
+}
+
+func (s *HealthTestSuite) TestValidate(t *C) {
+	t.Check(HealthConfig{}.Validate(), HasLen, 0)
+	t.Check(HealthConfig{Listen: ":8080", BackendFailureThreshold: "1m", UnhealthyWindow: "5m", UnhealthyFailures: 3}.Validate(), HasLen, 0)
+	problems := HealthConfig{Listen: "8080", BackendFailureThreshold: "1", UnhealthyWindow: "x", UnhealthyFailures: -1}.Validate()
+	t.Check(problems, HasLen, 4)
+}
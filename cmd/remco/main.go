@@ -13,26 +13,50 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
-	"reflect"
 	"sync"
 	"syscall"
 
+	"github.com/HeavyHorst/remco/pkg/child"
 	"github.com/HeavyHorst/remco/pkg/log"
 	"github.com/hashicorp/consul-template/signals"
 	"github.com/hashicorp/go-reap"
+	"github.com/pkg/errors"
 )
 
+const defaultConfig = "/etc/remco/config"
+
+// exitResourceFailed is the exit code if a resource with on_failure = "exit" failed too often.
+// It is larger than the number of resources with errors, which is capped at 125.
+const exitResourceFailed = 126
+
 var (
 	configPath          string
 	printVersionAndExit bool
 	onetime             bool
+	dryRun              bool
 )
 
 func init() {
-	const defaultConfig = "/etc/remco/config"
 	flag.StringVar(&configPath, "config", defaultConfig, "path to the configuration file")
 	flag.BoolVar(&printVersionAndExit, "version", false, "print version and exit")
 	flag.BoolVar(&onetime, "onetime", false, "run templating process once and exit")
+	flag.BoolVar(&dryRun, "dry-run", false, "print a diff of the changes instead of writing the files, implies -onetime")
+}
+
+// applyFlags applies the command line flags to the configuration.
+func applyFlags(cfg *Configuration) {
+	for i := range cfg.Resource {
+		res := &cfg.Resource[i]
+		if dryRun {
+			res.DryRun = true
+		}
+		if onetime || dryRun {
+			for _, b := range res.Backends.GetBackends() {
+				backend := b.GetBackend()
+				backend.Onetime = true
+			}
+		}
+	}
 }
 
 func run() int32 {
@@ -48,20 +72,15 @@ func run() int32 {
 		log.Fatal("failed to read config", err)
 	}
 
-	if onetime {
-		for _, res := range cfg.Resource {
-			for _, b := range res.Backends.GetBackends() {
-				if !reflect.ValueOf(b).IsZero() {
-					backend := b.GetBackend()
-					backend.Onetime = true
-				}
-			}
-		}
-	}
+	applyFlags(&cfg)
 
 	run := NewSupervisor(cfg, reapLock, done)
 	defer run.Stop()
 
+	// notify systemd if remco runs as a unit of Type=notify
+	notifier := newSystemdNotifier(run)
+	defer notifier.Close()
+
 	// reap zombies if pid is 1
 	pidReapChan := make(reap.PidCh, 1)
 	errorReapChan := make(reap.ErrorCh, 1)
@@ -73,20 +92,74 @@ func run() int32 {
 		}
 	}
 
+	// watch the configuration for changes if enabled
+	var watcher *configWatcher
+	var watchChan chan struct{}
+	watchConfig := func(cfg Configuration) {
+		if watcher != nil {
+			watcher.Close()
+			watcher, watchChan = nil, nil
+		}
+		if !cfg.WatchConfig || onetime || dryRun {
+			return
+		}
+		w, err := newConfigWatcher(configPath, cfg)
+		if err != nil {
+			log.Error("failed to watch config", err)
+			return
+		}
+		watcher, watchChan = w, w.C
+	}
+	watchConfig(cfg)
+	defer func() {
+		if watcher != nil {
+			watcher.Close()
+		}
+	}()
+
+	apply := func(newConf Configuration) {
+		notifier.Reloading()
+		run.Reload(newConf)
+		notifier.Reloaded()
+		watchConfig(newConf)
+	}
+
+	load := func() (Configuration, error) {
+		log.WithFields(
+			"file", configPath,
+		).Info("loading new config")
+		newConf, err := NewConfiguration(configPath)
+		if err != nil {
+			log.Error("failed to read config", err)
+			return newConf, errors.Wrap(err, "failed to read config")
+		}
+		applyFlags(&newConf)
+		return newConf, nil
+	}
+
+	reload := func() {
+		if newConf, err := load(); err == nil {
+			apply(newConf)
+		}
+	}
+
 	for {
 		select {
+		case <-watchChan:
+			reload()
+		case reply := <-run.ReloadRequests():
+			newConf, err := load()
+			reply <- err
+			if err == nil {
+				apply(newConf)
+			}
+		case name := <-run.ExitRequests():
+			log.WithFields("resource", name).Error("resource failed, exiting")
+			return exitResourceFailed
 		case s := <-signalChan:
 			switch s {
 			case syscall.SIGHUP:
-				log.WithFields(
-					"file", configPath,
-				).Info("loading new config")
-				newConf, err := NewConfiguration(configPath)
-				if err != nil {
-					log.Error("failed to read config", err)
-					continue
-				}
-				run.Reload(newConf)
+				reload()
 			case signals.SignalLookup["SIGCHLD"]:
 			case os.Interrupt, syscall.SIGTERM:
 				log.Info(fmt.Sprintf("Captured %v. Exiting...", s))
@@ -99,22 +172,37 @@ func run() int32 {
 		case err := <-errorReapChan:
 			log.Error(fmt.Sprintf("Error reaping child process %v", err))
 		case <-done:
-			return run.getNumResourceErrors()
+			rc := run.getNumResourceErrors()
+			// be on the safe side for portability and lots of backend resources
+			if rc > 125 {
+				rc = 125
+			}
+			return rc
 		}
 	}
 }
 
 func main() {
+	// remco starts itself as a helper to set the umask and the resource limits of child processes
+	child.RunHelper()
+
+	// subcommands have their own flags
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "restore":
+			os.Exit(runRestore(os.Args[2:]))
+		case "ctl":
+			os.Exit(runCtl(os.Args[2:]))
+		}
+	}
+
 	flag.Parse()
 
 	if printVersionAndExit {
 		printVersion()
 		return
 	}
-	rc := run()
-	// be on the safe side for portability and lots of backend resources
-	if rc > 125 {
-		rc = 125
-	}
-	os.Exit(int(rc))
+	os.Exit(int(run()))
 }
@@ -0,0 +1,132 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/HeavyHorst/remco/pkg/log"
+	"github.com/HeavyHorst/remco/pkg/template"
+	"github.com/HeavyHorst/remco/pkg/template/fileutil"
+	"github.com/pkg/errors"
+)
+
+// findTemplate returns the template with the given dst file.
+func findTemplate(cfg Configuration, dst string) (*template.Renderer, error) {
+	dst = filepath.Clean(dst)
+	for _, r := range cfg.Resource {
+		for _, t := range r.Template {
+			if filepath.Clean(t.Dst) == dst {
+				return t, nil
+			}
+		}
+	}
+	return nil, errors.Errorf("no template with dst %s configured", dst)
+}
+
+// selectBackup returns the backup with the given version.
+// The version is the name of the backup file or a prefix of the content hash.
+// If version is empty, the newest backup that differs from the current file is returned.
+func selectBackup(backups []fileutil.Backup, version, currentHash string) (fileutil.Backup, error) {
+	for _, b := range backups {
+		switch {
+		case version == "" && b.Hash != currentHash:
+			return b, nil
+		case version != "" && (b.Version() == version || strings.HasPrefix(b.Hash, version)):
+			return b, nil
+		}
+	}
+	if version == "" {
+		return fileutil.Backup{}, errors.New("no older version found")
+	}
+	return fileutil.Backup{}, errors.Errorf("version %s not found", version)
+}
+
+// restore restores a backup of the template file dst.
+// If list is true, the available backups are printed instead.
+func restore(configPath, dst, version string, list bool) error {
+	cfg, err := NewConfiguration(configPath)
+	if err != nil {
+		return err
+	}
+	t, err := findTemplate(cfg, dst)
+	if err != nil {
+		return err
+	}
+	if t.BackupDir == "" {
+		return errors.Errorf("backups are not enabled for %s, set backup_dir", t.Dst)
+	}
+
+	backups, err := fileutil.ListBackups(t.Dst, t.BackupDir)
+	if err != nil {
+		return err
+	}
+	if list {
+		for _, b := range backups {
+			fmt.Printf("%s\t%s\n", b.Version(), b.Time.Local().Format("2006-01-02 15:04:05"))
+		}
+		return nil
+	}
+
+	var currentHash string
+	if fileutil.IsFileExist(t.Dst) {
+		if currentHash, err = fileutil.Hash(t.Dst); err != nil {
+			return err
+		}
+	}
+	b, err := selectBackup(backups, version, currentHash)
+	if err != nil {
+		return err
+	}
+
+	// save the current file, so that the restore can be undone
+	keep := t.BackupKeep
+	if keep <= 0 {
+		keep = template.DefaultBackupKeep
+	}
+	if err := fileutil.BackupFile(t.Dst, t.BackupDir, keep); err != nil {
+		return errors.Wrap(err, "backup failed")
+	}
+	if err := fileutil.RestoreBackup(b, t.Dst, log.WithFields("config", t.Dst)); err != nil {
+		return err
+	}
+	fmt.Printf("restored %s from version %s\n", t.Dst, b.Version())
+	return nil
+}
+
+// runRestore implements the restore command.
+// It returns the exit code.
+func runRestore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	path := fs.String("config", defaultConfig, "path to the configuration file")
+	version := fs.String("version", "", "the version to restore, defaults to the previous version")
+	list := fs.Bool("list", false, "list the available versions")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: remco restore [-config path] [-version version] [-list] <dst>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	// allow the flags after the dst file
+	dst := fs.Arg(0)
+	fs.Parse(fs.Args()[min(1, fs.NArg()):])
+	if dst == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	if err := restore(*path, dst, *version, *list); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,80 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/HeavyHorst/remco/pkg/template/fileutil"
+	. "gopkg.in/check.v1"
+)
+
+type RestoreSuite struct {
+	dir       string
+	dst       string
+	backupDir string
+	config    string
+}
+
+var _ = Suite(&RestoreSuite{})
+
+func (s *RestoreSuite) SetUpTest(t *C) {
+	s.dir = t.MkDir()
+	s.dst = filepath.Join(s.dir, "app.conf")
+	s.backupDir = filepath.Join(s.dir, "backup")
+	s.config = filepath.Join(s.dir, "config.toml")
+	config := fmt.Sprintf(`
+[[resource]]
+  [[resource.template]]
+    src = "%s"
+    dst = "%s"
+    backup_dir = "%s"
+  [resource.backend.mock]
+    keys = ["/"]
+`, s.dst+".tmpl", s.dst, s.backupDir)
+	t.Assert(ioutil.WriteFile(s.config, []byte(config), 0644), IsNil)
+
+	for _, content := range []string{"v1", "v2"} {
+		t.Assert(ioutil.WriteFile(s.dst, []byte(content), 0644), IsNil)
+		t.Assert(fileutil.BackupFile(s.dst, s.backupDir, 10), IsNil)
+	}
+}
+
+func (s *RestoreSuite) checkContent(t *C, expected string) {
+	data, err := ioutil.ReadFile(s.dst)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, expected)
+}
+
+func (s *RestoreSuite) TestRestorePrevious(t *C) {
+	t.Assert(restore(s.config, s.dst, "", false), IsNil)
+	s.checkContent(t, "v1")
+
+	// the restore can be undone
+	t.Assert(restore(s.config, s.dst, "", false), IsNil)
+	s.checkContent(t, "v2")
+}
+
+func (s *RestoreSuite) TestRestoreVersion(t *C) {
+	backups, err := fileutil.ListBackups(s.dst, s.backupDir)
+	t.Assert(err, IsNil)
+	t.Assert(backups, HasLen, 2)
+
+	t.Assert(restore(s.config, s.dst, backups[1].Version(), false), IsNil)
+	s.checkContent(t, "v1")
+	t.Assert(restore(s.config, s.dst, backups[0].Hash[:8], false), IsNil)
+	s.checkContent(t, "v2")
+	t.Check(restore(s.config, s.dst, "unknown", false), ErrorMatches, "version unknown not found")
+}
+
+func (s *RestoreSuite) TestRestoreUnknownDst(t *C) {
+	t.Check(restore(s.config, "/etc/unknown.conf", "", false), ErrorMatches, "no template with dst .* configured")
+}
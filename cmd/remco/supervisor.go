@@ -10,14 +10,20 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/HeavyHorst/memkv"
 	"github.com/HeavyHorst/remco/pkg/log"
 	"github.com/HeavyHorst/remco/pkg/telemetry"
 	"github.com/HeavyHorst/remco/pkg/template"
@@ -30,17 +36,74 @@ type reloadSignal struct {
 	reloaded chan<- struct{}
 }
 
+// runningResource is a resource that is managed by the Supervisor.
+type runningResource struct {
+	key         string
+	fingerprint string
+	cancel      context.CancelFunc
+	done        chan struct{}
+
+	// res is the running resource, it is nil until the resource was created.
+	res      *template.Resource
+	resMutex sync.RWMutex
+
+	// restarts is the number of restarts of the resource after a failure, it is accessed atomically.
+	restarts int32
+}
+
+func (rr *runningResource) setResource(res *template.Resource) {
+	rr.resMutex.Lock()
+	defer rr.resMutex.Unlock()
+	rr.res = res
+}
+
+func (rr *runningResource) resource() *template.Resource {
+	rr.resMutex.RLock()
+	defer rr.resMutex.RUnlock()
+	return rr.res
+}
+
+// ResourceStatus is the status of a resource.
+type ResourceStatus struct {
+	Name string `json:"name"`
+	// Ready is true if the templates were rendered and the child process is ready.
+	Ready bool `json:"ready"`
+	template.Status
+}
+
+// resourceNotFound is the error returned for an unknown resource name.
+type resourceNotFound string
+
+func (e resourceNotFound) Error() string {
+	return fmt.Sprintf("resource %q not found", string(e))
+}
+
 // Supervisor runs
 type Supervisor struct {
-	stopChan   chan struct{}
-	reloadChan chan reloadSignal
-	wg         sync.WaitGroup
+	stopChan     chan struct{}
+	reloadChan   chan reloadSignal
+	finishedChan chan *runningResource
+	pingChan     chan struct{}
+	// reloadRequests receives the reloads that were requested over the admin API.
+	// The main function loads the configuration and replies with the error, if any.
+	reloadRequests chan chan<- error
+	// exitRequests receives the names of the resources that failed with on_failure = "exit".
+	exitRequests chan string
+	wg           sync.WaitGroup
+
+	// resources is only modified by the main routine,
+	// other routines need to hold resourcesMutex.
+	resources      map[string]*runningResource
+	resourcesMutex sync.RWMutex
 
 	signalChans      map[string]chan os.Signal
 	signalChansMutex sync.RWMutex
 
 	pidFile   string
 	telemetry telemetry.Telemetry
+	admin     *adminServer
+	control   *adminServer
+	health    *healthServer
 
 	reapLock *sync.RWMutex
 
@@ -50,10 +113,15 @@ type Supervisor struct {
 // NewSupervisor creates a new Supervisor
 func NewSupervisor(cfg Configuration, reapLock *sync.RWMutex, done chan struct{}) *Supervisor {
 	w := &Supervisor{
-		stopChan:    make(chan struct{}),
-		reloadChan:  make(chan reloadSignal),
-		signalChans: make(map[string]chan os.Signal),
-		reapLock:    reapLock,
+		stopChan:       make(chan struct{}),
+		reloadChan:     make(chan reloadSignal),
+		finishedChan:   make(chan *runningResource),
+		pingChan:       make(chan struct{}),
+		reloadRequests: make(chan chan<- error),
+		exitRequests:   make(chan string, 1),
+		resources:      make(map[string]*runningResource),
+		signalChans:    make(map[string]chan os.Signal),
+		reapLock:       reapLock,
 	}
 
 	w.pidFile = cfg.PidFile
@@ -64,14 +132,15 @@ func NewSupervisor(cfg Configuration, reapLock *sync.RWMutex, done chan struct{}
 		log.WithFields("pid_file", w.pidFile).Error("failed to write pidfile", err)
 	}
 
-	stopChan := make(chan struct{})
-	stoppedChan := make(chan struct{})
-
+	// the health endpoints are added to the prometheus sink before it is started
+	w.updateHealth(cfg.Health, cfg.Telemetry)
 	_, err = w.telemetry.Init()
 	if err != nil {
 		log.Error(fmt.Sprintf("error starting telemetry: %v", err))
 	}
-	go w.runResource(cfg.Resource, stopChan, stoppedChan)
+	w.updateAdmin(cfg.Admin)
+	w.updateControl(cfg.ControlSocket)
+	w.updateResources(cfg)
 	w.wg.Add(1)
 	go func() {
 		defer w.wg.Done()
@@ -80,6 +149,12 @@ func NewSupervisor(cfg Configuration, reapLock *sync.RWMutex, done chan struct{}
 		// for example all backends are configured with onetime=true
 		defer close(done)
 		for {
+			// If there is no resource left - quit
+			// this is necessary for the onetime mode
+			if len(w.resources) == 0 {
+				return
+			}
+
 			select {
 			case rs := <-w.reloadChan:
 				// write a new pidfile if the pid filepath has changed
@@ -98,19 +173,26 @@ func NewSupervisor(cfg Configuration, reapLock *sync.RWMutex, done chan struct{}
 				if err != nil {
 					log.Error(fmt.Sprintf("error stopping telemetry: %v", err))
 				}
+				w.updateHealth(rs.c.Health, rs.c.Telemetry)
 				_, err = rs.c.Telemetry.Init()
 				if err != nil {
 					log.Error(fmt.Sprintf("error starting telemetry: %v", err))
 				}
-				stopChan <- struct{}{}
-				<-stoppedChan
-				go w.runResource(rs.c.Resource, stopChan, stoppedChan)
+				// the new sinks are stopped on the next reload
+				w.telemetry = rs.c.Telemetry
+				w.updateAdmin(rs.c.Admin)
+				w.updateControl(rs.c.ControlSocket)
+				w.updateResources(rs.c)
 				rs.reloaded <- struct{}{}
-			case <-stoppedChan:
-				return
+			case rr := <-w.finishedChan:
+				if w.resources[rr.key] == rr {
+					w.resourcesMutex.Lock()
+					delete(w.resources, rr.key)
+					w.resourcesMutex.Unlock()
+				}
+			case <-w.pingChan:
 			case <-w.stopChan:
-				stopChan <- struct{}{}
-				<-stoppedChan
+				w.stopResources(w.resources)
 				return
 			}
 		}
@@ -119,6 +201,318 @@ func NewSupervisor(cfg Configuration, reapLock *sync.RWMutex, done chan struct{}
 	return w
 }
 
+// updateAdmin restarts the admin API if its configuration changed.
+func (ru *Supervisor) updateAdmin(c AdminConfig) {
+	if ru.admin != nil && ru.admin.config == c {
+		return
+	}
+	if err := ru.admin.Close(); err != nil {
+		log.Error(fmt.Sprintf("error stopping the admin api: %v", err))
+	}
+	admin, err := newAdminServer(c, ru)
+	if err != nil {
+		log.Error(fmt.Sprintf("error starting the admin api: %v", err))
+	}
+	ru.admin = admin
+}
+
+// updateControl restarts the control socket if its path changed.
+func (ru *Supervisor) updateControl(path string) {
+	if ru.control != nil && ru.control.config.Listen == unixPrefix+path {
+		return
+	}
+	if err := ru.control.Close(); err != nil {
+		log.Error(fmt.Sprintf("error stopping the control socket: %v", err))
+	}
+	control, err := newControlServer(path, ru)
+	if err != nil {
+		log.Error(fmt.Sprintf("error starting the control socket: %v", err))
+	}
+	ru.control = control
+}
+
+// updateHealth restarts the health endpoints with the configuration c.
+// It must be called before the telemetry t is started.
+func (ru *Supervisor) updateHealth(c HealthConfig, t telemetry.Telemetry) {
+	if err := ru.health.Close(); err != nil {
+		log.Error(fmt.Sprintf("error stopping the health endpoint: %v", err))
+	}
+	health, err := newHealthServer(c, t, ru)
+	if err != nil {
+		log.Error(fmt.Sprintf("error starting the health endpoint: %v", err))
+	}
+	ru.health = health
+}
+
+// resourceKeys returns a unique key for every resource.
+// The key is the resource name, duplicate names get a numeric suffix.
+func resourceKeys(r []Resource) []string {
+	keys := make([]string, len(r))
+	seen := make(map[string]int)
+	for i, v := range r {
+		n := seen[v.Name]
+		seen[v.Name] = n + 1
+		if n == 0 {
+			keys[i] = v.Name
+		} else {
+			keys[i] = fmt.Sprintf("%s#%d", v.Name, n)
+		}
+	}
+	return keys
+}
+
+// resourceFingerprint returns a string that changes whenever the configuration of r,
+// the content of its template sources or the custom filters change.
+// It must be called before the resource is started, since the backends and templates are
+// modified while the resource runs.
+func resourceFingerprint(r Resource, filters string) string {
+	buf, err := json.Marshal(r)
+	if err != nil {
+		// treat the resource as changed on every reload
+		return uuid.New()
+	}
+	h := sha256.New()
+	h.Write(buf)
+	h.Write([]byte(filters))
+	for _, t := range r.Template {
+		src, err := ioutil.ReadFile(t.Src)
+		if err != nil {
+			// a missing template is reported by the resource itself
+			h.Write([]byte(err.Error()))
+			continue
+		}
+		h.Write(src)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// filterFingerprint returns a hash of the custom JavaScript filters in dir.
+func filterFingerprint(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	h := sha256.New()
+	files, _ := ioutil.ReadDir(dir)
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".js") {
+			continue
+		}
+		buf, _ := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		fmt.Fprintf(h, "%s\x00%d\x00", file.Name(), len(buf))
+		h.Write(buf)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// updateResources compares the running resources with the resources of c by name, configuration,
+// template sources and custom filters.
+// Resources that were removed or modified are stopped, resources that were added or modified are started.
+// Unchanged resources keep running.
+func (ru *Supervisor) updateResources(c Configuration) {
+	r := c.Resource
+	keys := resourceKeys(r)
+	filters := filterFingerprint(c.FilterDir)
+	fingerprints := make(map[string]string, len(r))
+	for i, v := range r {
+		fingerprints[keys[i]] = resourceFingerprint(v, filters)
+	}
+
+	stop := make(map[string]*runningResource)
+	for key, rr := range ru.resources {
+		fp, ok := fingerprints[key]
+		if !ok {
+			log.WithFields("resource", key).Info("resource removed, stopping")
+			stop[key] = rr
+		} else if fp != rr.fingerprint {
+			log.WithFields("resource", key).Info("resource modified, restarting")
+			stop[key] = rr
+		} else {
+			log.WithFields("resource", key).Debug("resource unchanged")
+		}
+	}
+	ru.stopResources(stop)
+
+	for i, v := range r {
+		if _, ok := ru.resources[keys[i]]; ok {
+			continue
+		}
+		ru.startResource(keys[i], fingerprints[keys[i]], v)
+	}
+}
+
+// startResource runs the resource r in a new goroutine.
+func (ru *Supervisor) startResource(key, fingerprint string, r Resource) {
+	ctx, cancel := context.WithCancel(context.Background())
+	rr := &runningResource{
+		key:         key,
+		fingerprint: fingerprint,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	ru.resourcesMutex.Lock()
+	ru.resources[key] = rr
+	ru.resourcesMutex.Unlock()
+
+	go func() {
+		defer cancel()
+		ru.runResource(ctx, rr, r)
+		close(rr.done)
+		// report the resource as finished unless it was stopped
+		select {
+		case ru.finishedChan <- rr:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// stopResources stops the given resources and waits until they are finished.
+func (ru *Supervisor) stopResources(rs map[string]*runningResource) {
+	for _, rr := range rs {
+		rr.cancel()
+	}
+	for key, rr := range rs {
+		<-rr.done
+		ru.resourcesMutex.Lock()
+		delete(ru.resources, key)
+		ru.resourcesMutex.Unlock()
+	}
+}
+
+// Status returns the status of all running resources, sorted by name.
+func (ru *Supervisor) Status() []ResourceStatus {
+	ru.resourcesMutex.RLock()
+	defer ru.resourcesMutex.RUnlock()
+	status := make([]ResourceStatus, 0, len(ru.resources))
+	for key, rr := range ru.resources {
+		rs := ResourceStatus{Name: key}
+		if res := rr.resource(); res != nil {
+			rs.Ready = res.Ready()
+			rs.Status = res.Status()
+		} else {
+			rs.State = template.StateStarting
+		}
+		rs.Restarts += int(atomic.LoadInt32(&rr.restarts))
+		status = append(status, rs)
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Name < status[j].Name
+	})
+	return status
+}
+
+// resourceFailures returns the number of failures after since of every running resource.
+func (ru *Supervisor) resourceFailures(since time.Time) map[string]int {
+	ru.resourcesMutex.RLock()
+	defer ru.resourcesMutex.RUnlock()
+	failures := make(map[string]int, len(ru.resources))
+	for key, rr := range ru.resources {
+		if res := rr.resource(); res != nil {
+			failures[key] = res.Failures(since)
+		}
+	}
+	return failures
+}
+
+// lookupResource returns the running resource with the given name.
+func (ru *Supervisor) lookupResource(name string) (*template.Resource, error) {
+	ru.resourcesMutex.RLock()
+	rr, ok := ru.resources[name]
+	ru.resourcesMutex.RUnlock()
+	if !ok {
+		return nil, resourceNotFound(name)
+	}
+	res := rr.resource()
+	if res == nil {
+		return nil, fmt.Errorf("resource %q is not started yet", name)
+	}
+	return res, nil
+}
+
+// RenderResource renders the templates of the resource name with the current data of all backends.
+func (ru *Supervisor) RenderResource(name string) error {
+	res, err := ru.lookupResource(name)
+	if err != nil {
+		return err
+	}
+	res.Render()
+	return nil
+}
+
+// PauseResource stops rendering the backend changes of the resource name.
+func (ru *Supervisor) PauseResource(name string) error {
+	res, err := ru.lookupResource(name)
+	if err != nil {
+		return err
+	}
+	res.Pause()
+	return nil
+}
+
+// ResumeResource renders the backend changes of the paused resource name again.
+func (ru *Supervisor) ResumeResource(name string) error {
+	res, err := ru.lookupResource(name)
+	if err != nil {
+		return err
+	}
+	res.Resume()
+	return nil
+}
+
+// SignalResource sends the signal s to the child process of the resource name.
+func (ru *Supervisor) SignalResource(name string, s os.Signal) error {
+	res, err := ru.lookupResource(name)
+	if err != nil {
+		return err
+	}
+	return res.Signal(s)
+}
+
+// ResourceData returns the current key-value pairs of the resource name.
+func (ru *Supervisor) ResourceData(name string) (memkv.KVPairs, error) {
+	res, err := ru.lookupResource(name)
+	if err != nil {
+		return nil, err
+	}
+	return res.Data(), nil
+}
+
+// RequestReload asks the main function to reload the configuration file like SIGHUP.
+// It returns the error of reading the configuration, the reload itself runs in the background.
+func (ru *Supervisor) RequestReload(ctx context.Context) error {
+	reply := make(chan error, 1)
+	select {
+	case ru.reloadRequests <- reply:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ReloadRequests returns the channel of the requested reloads.
+// The main function has to reply on every received channel.
+func (ru *Supervisor) ReloadRequests() <-chan chan<- error {
+	return ru.reloadRequests
+}
+
+// requestExit asks the main function to exit because the resource name failed.
+func (ru *Supervisor) requestExit(name string) {
+	select {
+	case ru.exitRequests <- name:
+	default:
+		// another resource already requested the exit
+	}
+}
+
+// ExitRequests returns the channel of the resources that requested the exit of remco.
+func (ru *Supervisor) ExitRequests() <-chan string {
+	return ru.exitRequests
+}
+
 func (ru *Supervisor) getNumResourceErrors() int32 {
 	return atomic.LoadInt32(&ru.resourcesWithError)
 }
@@ -186,94 +580,81 @@ func (ru *Supervisor) SendSignal(s os.Signal) {
 	}
 }
 
-func (ru *Supervisor) runResource(r []Resource, stop, stopped chan struct{}) {
-	defer func() {
-		if stopped != nil {
-			stopped <- struct{}{}
-		}
-	}()
+func (ru *Supervisor) runResource(ctx context.Context, rr *runningResource, r Resource) {
+	rsc := template.ResourceConfig{
+		Exec:         r.Exec,
+		Template:     r.Template,
+		Name:         r.Name,
+		StartCmd:     r.StartCmd,
+		ReloadCmd:    r.ReloadCmd,
+		KeyCollision: r.KeyCollision,
+		Wait:         r.Wait,
+		DryRun:       r.DryRun,
+		Cache:        r.Cache,
+		Connectors:   r.Backends.GetBackends(),
+
+		FailurePolicy: r.failurePolicy(),
+
+		RollbackOnReloadFailure: r.RollbackOnReloadFailure,
+	}
+	res, err := template.NewResourceFromResourceConfig(ctx, ru.reapLock, rsc)
+	if err != nil {
+		log.Error("failed to create new resource", err)
+		ru.incResourceError()
+		return
+	}
+	defer res.Close()
+	rr.setResource(res)
+	defer rr.setResource(nil)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	done := make(chan struct{})
-
-	wait := sync.WaitGroup{}
-	for _, v := range r {
-		wait.Add(1)
-		go func(r Resource) {
-			defer wait.Done()
-
-			rsc := template.ResourceConfig{
-				Exec:       r.Exec,
-				Template:   r.Template,
-				Name:       r.Name,
-				StartCmd:   r.StartCmd,
-				ReloadCmd:  r.ReloadCmd,
-				Connectors: r.Backends.GetBackends(),
-			}
-			res, err := template.NewResourceFromResourceConfig(ctx, ru.reapLock, rsc)
-			if err != nil {
-				log.Error("failed to create new resource", err)
+	id := uuid.New()
+	ru.addSignalChan(id, res.SignalChan)
+	defer ru.removeSignalChan(id)
+
+	restartChan := make(chan struct{}, 1)
+	restartChan <- struct{}{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-restartChan:
+			res.Monitor(ctx)
+			if res.Failed && res.OnetimeOnly {
 				ru.incResourceError()
 				return
-			}
-			defer res.Close()
-
-			id := uuid.New()
-			ru.addSignalChan(id, res.SignalChan)
-			defer ru.removeSignalChan(id)
-
-			restartChan := make(chan struct{}, 1)
-			restartChan <- struct{}{}
-
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-restartChan:
-					res.Monitor(ctx)
-					if res.Failed && res.OnetimeOnly {
-						ru.incResourceError()
+			} else if res.Failed {
+				if res.FailureLimitReached() {
+					switch res.OnFailure() {
+					case template.ExitOnFailure:
+						log.WithFields("resource", r.Name).Error("resource failed too often, exiting")
+						ru.requestExit(r.Name)
+						// keep the resource until remco is stopped, so that it doesn't finish before the exit
+						<-ctx.Done()
 						return
-					} else if res.Failed {
-						go func() {
-							// try to restart the resource after a random amount of time
-							rn := rand.Int63n(30)
-							log.WithFields(
-								"resource", r.Name,
-								"restartDelay", rn,
-							).Error("resource execution failed, restarting after delay")
-							time.Sleep(time.Duration(rn) * time.Second)
-							select {
-							case <-ctx.Done():
-								return
-							default:
-								restartChan <- struct{}{}
-							}
-						}()
-					} else {
+					case template.IgnoreOnFailure:
+						log.WithFields("resource", r.Name).Error("resource failed too often, giving up")
+						ru.incResourceError()
 						return
 					}
 				}
+				atomic.AddInt32(&rr.restarts, 1)
+				// try to restart the resource after an exponential backoff
+				delay := res.RestartDelay()
+				go func() {
+					log.WithFields(
+						"resource", r.Name,
+						"restartDelay", delay.String(),
+					).Error("resource execution failed, restarting after delay")
+					select {
+					case <-ctx.Done():
+					case <-time.After(delay):
+						restartChan <- struct{}{}
+					}
+				}()
+			} else {
+				return
 			}
-		}(v)
-	}
-
-	go func() {
-		// If there is no goroutine left - quit
-		// this is necessary for the onetime mode
-		wait.Wait()
-		close(done)
-	}()
-
-	for {
-		select {
-		case <-stop:
-			cancel()
-			wait.Wait()
-			return
-		case <-done:
-			return
 		}
 	}
 }
@@ -288,12 +669,50 @@ func (ru *Supervisor) Reload(cfg Configuration) {
 	<-reloaded
 }
 
+// Ping checks that the main routine of the Supervisor and the Monitor loop of every resource are responsive.
+// It returns an error if one of them doesn't respond within timeout.
+func (ru *Supervisor) Ping(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case ru.pingChan <- struct{}{}:
+	case <-timer.C:
+		return fmt.Errorf("the supervisor didn't respond within %s", timeout)
+	}
+
+	ru.resourcesMutex.RLock()
+	resources := make([]*template.Resource, 0, len(ru.resources))
+	for _, rr := range ru.resources {
+		if res := rr.resource(); res != nil {
+			resources = append(resources, res)
+		}
+	}
+	ru.resourcesMutex.RUnlock()
+	for _, res := range resources {
+		if err := res.Ping(time.Until(deadline)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Stop stops the Supervisor gracefully.
 func (ru *Supervisor) Stop() int32 {
 	close(ru.stopChan)
 	// wait for the main routine to exit
 	ru.wg.Wait()
 
+	if err := ru.admin.Close(); err != nil {
+		log.Error(fmt.Sprintf("error stopping the admin api: %v", err))
+	}
+	if err := ru.control.Close(); err != nil {
+		log.Error(fmt.Sprintf("error stopping the control socket: %v", err))
+	}
+	if err := ru.health.Close(); err != nil {
+		log.Error(fmt.Sprintf("error stopping the health endpoint: %v", err))
+	}
+
 	// remove the pidfile
 	err := ru.deletePid()
 	if err != nil {
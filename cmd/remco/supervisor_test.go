@@ -9,7 +9,10 @@
 package main
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/HeavyHorst/remco/pkg/backends"
 	"github.com/HeavyHorst/remco/pkg/telemetry"
@@ -27,14 +30,14 @@ var exampleTemplates = []*template.Renderer{
 }
 
 var exampleBackend = BackendConfigs{
-	Mock: &backends.MockConfig{
+	Mock: []*backends.MockConfig{{
 		Backend: template.Backend{
 			Watch:    false,
 			Keys:     []string{"/"},
 			Interval: 1,
 			Onetime:  false,
 		},
-	},
+	}},
 }
 
 var exampleConfiguration = Configuration{
@@ -75,6 +78,11 @@ func (s *RunnerTestSuite) TestNew(t *C) {
 	t.Check(s.runner.telemetry, DeepEquals, exampleConfiguration.Telemetry)
 }
 
+func (s *RunnerTestSuite) TestPing(t *C) {
+	t.Check(s.runner.Ping(time.Second), IsNil)
+	t.Check((&Supervisor{}).Ping(10*time.Millisecond), NotNil)
+}
+
 func (s *RunnerTestSuite) TestWritePid(t *C) {
 	err := s.runner.writePid(os.Getpid())
 	t.Check(err, IsNil)
@@ -105,6 +113,121 @@ func (s *RunnerTestSuite) TestReload(t *C) {
 	s.runner.Reload(new)
 }
 
+// resources returns a snapshot of the running resources.
+// The entries are compared by pointer only, their fields are written by the resource goroutines.
+func (s *RunnerTestSuite) resources() map[string]*runningResource {
+	s.runner.resourcesMutex.RLock()
+	defer s.runner.resourcesMutex.RUnlock()
+	rs := make(map[string]*runningResource, len(s.runner.resources))
+	for k, v := range s.runner.resources {
+		rs[k] = v
+	}
+	return rs
+}
+
+func (s *RunnerTestSuite) TestReloadOnlyChangedResources(t *C) {
+	s.runner.Reload(exampleConfiguration)
+	resources := s.resources()
+	t.Assert(len(resources), Equals, 1)
+	unchanged := resources["test.toml"]
+
+	// reloading the same configuration keeps the resource running
+	s.runner.Reload(exampleConfiguration)
+	t.Check(s.resources()["test.toml"] == unchanged, Equals, true)
+
+	// a modified and an added resource
+	modified := exampleConfiguration
+	modified.Resource = []Resource{
+		{
+			Name:      "test.toml",
+			Template:  []*template.Renderer{{Src: "/tmp/test12345.tmpl", Dst: "/tmp/test12345.cfg"}},
+			Backends:  exampleBackend.Copy(),
+			ReloadCmd: "true",
+		},
+		{
+			Name:     "other.toml",
+			Template: []*template.Renderer{{Src: "/tmp/test12345.tmpl", Dst: "/tmp/test12345.cfg"}},
+			Backends: exampleBackend.Copy(),
+		},
+	}
+	s.runner.Reload(modified)
+	resources = s.resources()
+	t.Assert(len(resources), Equals, 2)
+	t.Check(resources["test.toml"] != unchanged, Equals, true)
+	select {
+	case <-unchanged.done:
+	default:
+		t.Error("the modified resource wasn't stopped")
+	}
+	t.Check(resources["other.toml"] != nil, Equals, true)
+
+	// a removed resource
+	s.runner.Reload(exampleConfiguration)
+	resources = s.resources()
+	t.Check(len(resources), Equals, 1)
+	t.Check(resources["other.toml"] == nil, Equals, true)
+
+	status := s.runner.Status()
+	t.Assert(status, HasLen, 1)
+	t.Check(status[0].Name, Equals, "test.toml")
+}
+
+func (s *RunnerTestSuite) TestResourceKeys(t *C) {
+	keys := resourceKeys([]Resource{{Name: "a"}, {Name: "b"}, {Name: "a"}})
+	t.Check(keys, DeepEquals, []string{"a", "b", "a#1"})
+}
+
+// waitForFile waits until the file at path has the content want.
+func waitForFile(t *C, path, want string) {
+	var got []byte
+	for i := 0; i < 100; i++ {
+		got, _ = ioutil.ReadFile(path)
+		if string(got) == want {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Errorf("%s: got %q, want %q", path, got, want)
+}
+
+func (s *RunnerTestSuite) TestReloadChangedSources(t *C) {
+	dir := t.MkDir()
+	filterDir := filepath.Join(dir, "filters")
+	t.Assert(os.Mkdir(filterDir, 0755), IsNil)
+	filter := filepath.Join(filterDir, "greet.js")
+	src := filepath.Join(dir, "test.tmpl")
+	dst := filepath.Join(dir, "test.cfg")
+	t.Assert(ioutil.WriteFile(filter, []byte(`"hello " + In`), 0644), IsNil)
+	t.Assert(ioutil.WriteFile(src, []byte(`{{ "world"|greet }}`), 0644), IsNil)
+	t.Assert(template.RegisterCustomJsFilters(filterDir), IsNil)
+
+	// the backend doesn't render again on its own within the test
+	backend := exampleBackend.Copy()
+	backend.Mock[0].Interval = 3600
+	cfg := Configuration{
+		FilterDir: filterDir,
+		Resource: []Resource{{
+			Name:     "sources.toml",
+			Template: []*template.Renderer{{Src: src, Dst: dst, Mode: "0644"}},
+			Backends: backend,
+		}},
+	}
+	runner := NewSupervisor(cfg, nil, make(chan struct{}))
+	defer runner.Stop()
+	waitForFile(t, dst, "hello world")
+
+	// a changed filter restarts the resource although its configuration is unchanged
+	t.Assert(ioutil.WriteFile(filter, []byte(`"goodbye " + In`), 0644), IsNil)
+	t.Assert(template.RegisterCustomJsFilters(filterDir), IsNil)
+	runner.Reload(cfg)
+	waitForFile(t, dst, "goodbye world")
+
+	// a changed template source as well
+	t.Assert(ioutil.WriteFile(src, []byte(`{{ "moon"|greet }}`), 0644), IsNil)
+	runner.Reload(cfg)
+	waitForFile(t, dst, "goodbye moon")
+}
+
 func (s *RunnerTestSuite) TearDownSuite(t *C) {
 	s.runner.Stop()
 	t.Check(s.runner.signalChans, HasLen, 0)
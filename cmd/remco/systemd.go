@@ -0,0 +1,202 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/log"
+	"github.com/HeavyHorst/remco/pkg/template"
+	"github.com/coreos/go-systemd/v22/daemon"
+)
+
+// notifyInterval is the interval in which the notifier checks the resources.
+const notifyInterval = time.Second
+
+// resourceStates is the order of the states in the systemd status.
+var resourceStates = []template.ResourceState{
+	template.StateStarting,
+	template.StateRunning,
+	template.StatePaused,
+	template.StateFailed,
+	template.StateStopped,
+}
+
+// systemdNotifier reports the state of remco to systemd if it runs as a unit of Type=notify.
+// It sends READY=1 after all resources were rendered once, RELOADING=1 and READY=1 around a reload,
+// WATCHDOG=1 while the Supervisor and the resources are responsive or a reload is running
+// and a STATUS= with the number of resources in each state.
+type systemdNotifier struct {
+	supervisor *Supervisor
+	// watchdog is the interval of the watchdog pings, 0 if the watchdog is disabled.
+	watchdog time.Duration
+
+	mu        sync.Mutex
+	ready     bool
+	reloading bool
+	status    string
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// newSystemdNotifier starts notifying systemd about the state of the Supervisor.
+// It returns nil if remco wasn't started by systemd with a notification socket.
+func newSystemdNotifier(ru *Supervisor) *systemdNotifier {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return nil
+	}
+	n := &systemdNotifier{
+		supervisor: ru,
+		stopChan:   make(chan struct{}),
+	}
+	watchdog, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		log.Error(fmt.Sprintf("invalid systemd watchdog configuration: %v", err))
+	}
+	// ping twice per interval as recommended by sd_watchdog_enabled(3)
+	n.watchdog = watchdog / 2
+
+	n.wg.Add(1)
+	go n.run()
+	return n
+}
+
+func (n *systemdNotifier) run() {
+	defer n.wg.Done()
+
+	var watchdogChan <-chan time.Time
+	if n.watchdog > 0 {
+		ticker := time.NewTicker(n.watchdog)
+		defer ticker.Stop()
+		watchdogChan = ticker.C
+	}
+	ticker := time.NewTicker(notifyInterval)
+	defer ticker.Stop()
+
+	n.update()
+	for {
+		select {
+		case <-ticker.C:
+			n.update()
+		case <-watchdogChan:
+			// the Supervisor and the resources don't answer while they are reloaded
+			if !n.isReloading() {
+				if err := n.supervisor.Ping(n.watchdog); err != nil {
+					log.Warning(fmt.Sprintf("skipping the systemd watchdog notification: %v", err))
+					continue
+				}
+			}
+			n.notify(daemon.SdNotifyWatchdog)
+		case <-n.stopChan:
+			return
+		}
+	}
+}
+
+// update sends READY=1 once all resources were rendered and the status if it changed.
+func (n *systemdNotifier) update() {
+	status := n.supervisor.Status()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.ready && !n.reloading && rendered(status) {
+		n.ready = true
+		n.notify(daemon.SdNotifyReady)
+	}
+	if s := statusLine(status); s != n.status {
+		n.status = s
+		n.notify("STATUS=" + s)
+	}
+}
+
+// rendered reports whether all resources were rendered once or failed.
+func rendered(status []ResourceStatus) bool {
+	for _, rs := range status {
+		if rs.LastRender.IsZero() && rs.State != template.StateFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// statusLine returns the number of resources in each state, e.g. "3 resources: 2 running, 1 paused".
+func statusLine(status []ResourceStatus) string {
+	counts := make(map[template.ResourceState]int)
+	for _, rs := range status {
+		counts[rs.State]++
+	}
+	var parts []string
+	for _, state := range resourceStates {
+		if counts[state] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[state], state))
+		}
+	}
+	line := fmt.Sprintf("%d resources", len(status))
+	if len(parts) > 0 {
+		line += ": " + strings.Join(parts, ", ")
+	}
+	return line
+}
+
+// isReloading reports whether the configuration is reloaded.
+func (n *systemdNotifier) isReloading() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.reloading
+}
+
+// Reloading tells systemd that the configuration is reloaded.
+// Nothing is sent before remco was ready, systemd still waits for READY=1 then.
+func (n *systemdNotifier) Reloading() {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.reloading = true
+	if n.ready {
+		n.notify(daemon.SdNotifyReloading)
+	}
+}
+
+// Reloaded tells systemd that the reload is finished.
+// READY=1 is only sent if remco was ready before, otherwise it is sent once all resources were rendered.
+func (n *systemdNotifier) Reloaded() {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.reloading = false
+	if n.ready {
+		n.notify(daemon.SdNotifyReady)
+	}
+}
+
+// notify sends the state to systemd.
+func (n *systemdNotifier) notify(state string) {
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		log.Error(fmt.Sprintf("error notifying systemd: %v", err))
+	}
+}
+
+// Close tells systemd that remco is stopping and stops the notifications.
+func (n *systemdNotifier) Close() {
+	if n == nil {
+		return
+	}
+	close(n.stopChan)
+	n.wg.Wait()
+	n.notify(daemon.SdNotifyStopping)
+}
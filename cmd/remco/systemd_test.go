@@ -0,0 +1,115 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/template"
+
+	. "gopkg.in/check.v1"
+)
+
+type SystemdTestSuite struct{}
+
+var _ = Suite(&SystemdTestSuite{})
+
+func (s *SystemdTestSuite) TestStatusLine(t *C) {
+	status := []ResourceStatus{
+		{Name: "a", Status: template.Status{State: template.StateRunning, LastRender: time.Now()}},
+		{Name: "b", Status: template.Status{State: template.StatePaused, LastRender: time.Now()}},
+		{Name: "c", Status: template.Status{State: template.StateRunning, LastRender: time.Now()}},
+	}
+	t.Check(statusLine(status), Equals, "3 resources: 2 running, 1 paused")
+	t.Check(statusLine(nil), Equals, "0 resources")
+	t.Check(rendered(status), Equals, true)
+
+	status = append(status, ResourceStatus{Name: "d", Status: template.Status{State: template.StateFailed}})
+	t.Check(rendered(status), Equals, true)
+	status = append(status, ResourceStatus{Name: "e", Status: template.Status{State: template.StateStarting}})
+	t.Check(rendered(status), Equals, false)
+}
+
+func (s *SystemdTestSuite) TestNotify(t *C) {
+	socket := filepath.Join(t.MkDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	t.Assert(err, IsNil)
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	receive := func() string {
+		buf := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		t.Assert(err, IsNil)
+		return string(buf[:n])
+	}
+
+	runner := &Supervisor{resources: map[string]*runningResource{"starting": {key: "starting"}}}
+	n := &systemdNotifier{supervisor: runner}
+
+	// not ready before the resource was rendered
+	n.update()
+	t.Check(receive(), Equals, "STATUS=1 resources: 1 starting")
+	n.Reloading()
+	n.Reloaded()
+
+	delete(runner.resources, "starting")
+	n.update()
+	t.Check(receive(), Equals, "READY=1")
+	t.Check(receive(), Equals, "STATUS=0 resources")
+
+	n.Reloading()
+	t.Check(receive(), Equals, "RELOADING=1")
+	n.Reloaded()
+	t.Check(receive(), Equals, "READY=1")
+}
+
+func (s *SystemdTestSuite) TestWatchdog(t *C) {
+	socket := filepath.Join(t.MkDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	t.Assert(err, IsNil)
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	receive := func(timeout time.Duration) (string, error) {
+		buf := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, err := conn.Read(buf)
+		return string(buf[:n]), err
+	}
+
+	// the main routine of the supervisor doesn't run, so it never answers a ping
+	runner := &Supervisor{resources: map[string]*runningResource{}}
+	n := &systemdNotifier{supervisor: runner, watchdog: 20 * time.Millisecond, stopChan: make(chan struct{})}
+	n.wg.Add(1)
+	go n.run()
+	defer n.Close()
+
+	msg, _ := receive(time.Second)
+	t.Check(msg, Equals, "READY=1")
+	msg, _ = receive(time.Second)
+	t.Check(msg, Equals, "STATUS=0 resources")
+	_, err = receive(200 * time.Millisecond)
+	t.Check(err, NotNil, Commentf("the watchdog shouldn't be notified if the supervisor doesn't respond"))
+
+	// the watchdog is notified during a reload
+	n.Reloading()
+	msg, _ = receive(time.Second)
+	t.Check(msg, Equals, "RELOADING=1")
+	msg, err = receive(time.Second)
+	t.Check(err, IsNil)
+	t.Check(msg, Equals, "WATCHDOG=1")
+	n.Reloaded()
+}
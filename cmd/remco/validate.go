@@ -0,0 +1,183 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/HeavyHorst/remco/pkg/template"
+)
+
+// configProblem is a single problem found by validateConfiguration.
+type configProblem struct {
+	File string
+	Key  string
+	Err  error
+}
+
+func (p configProblem) String() string {
+	if p.Key == "" {
+		return fmt.Sprintf("%s: %v", p.File, p.Err)
+	}
+	return fmt.Sprintf("%s: %s: %v", p.File, p.Key, p.Err)
+}
+
+// validationConfiguration adds the default_backends to Configuration,
+// so that a single decode can report every undecoded key of the main configuration file.
+type validationConfiguration struct {
+	Configuration
+	Backends BackendConfigs `toml:"default_backends"`
+}
+
+// validateConfiguration loads the configuration file at path and every resource file in its include_dir
+// and checks them for errors.
+// Unlike NewConfiguration it doesn't stop at the first error, but returns every problem it finds.
+func validateConfiguration(path string) []configProblem {
+	var problems []configProblem
+
+	data, defaults, err := readConfig(path)
+	if err != nil {
+		return append(problems, configProblem{File: path, Err: err})
+	}
+
+	var c validationConfiguration
+	md, err := decodeConfig(path, data, &c)
+	if err != nil {
+		return append(problems, configProblem{File: path, Err: err})
+	}
+	problems = append(problems, undecodedKeys(path, md)...)
+
+	if c.WatchConfigDebounce != "" {
+		if _, err := time.ParseDuration(c.WatchConfigDebounce); err != nil {
+			problems = append(problems, configProblem{File: path, Key: "watch_config_debounce", Err: err})
+		}
+	}
+
+	if err := c.Admin.Validate(); err != nil {
+		problems = append(problems, configProblem{File: path, Key: "admin.listen", Err: err})
+	}
+
+	for _, p := range c.Health.Validate() {
+		p.File = path
+		problems = append(problems, p)
+	}
+
+	// the custom filters must be registered before the templates are compiled
+	if c.FilterDir != "" {
+		if err := template.RegisterCustomJsFilters(c.FilterDir); err != nil {
+			problems = append(problems, configProblem{File: path, Key: "filter_dir", Err: err})
+		}
+	}
+
+	for i, r := range c.Resource {
+		problems = append(problems, validateResource(path, fmt.Sprintf("resource[%d].", i), r)...)
+	}
+
+	if c.IncludeDir != "" {
+		files, err := ioutil.ReadDir(c.IncludeDir)
+		if err != nil {
+			return append(problems, configProblem{File: path, Key: "include_dir", Err: err})
+		}
+		for _, file := range files {
+			if !isConfigFile(file.Name()) {
+				continue
+			}
+			fp := filepath.Join(c.IncludeDir, file.Name())
+			data, err := readResource(fp, defaults)
+			if err != nil {
+				problems = append(problems, configProblem{File: fp, Err: err})
+				continue
+			}
+			var r Resource
+			md, err := decodeConfig(fp, data, &r)
+			if err != nil {
+				problems = append(problems, configProblem{File: fp, Err: err})
+				continue
+			}
+			problems = append(problems, undecodedKeys(fp, md)...)
+			// empty resources are ignored by NewConfiguration
+			if !r.empty() {
+				problems = append(problems, validateResource(fp, "", r)...)
+			}
+		}
+	}
+
+	return problems
+}
+
+func undecodedKeys(path string, md toml.MetaData) []configProblem {
+	var problems []configProblem
+	for _, key := range md.Undecoded() {
+		problems = append(problems, configProblem{File: path, Key: key.String(), Err: fmt.Errorf("unknown key")})
+	}
+	return problems
+}
+
+// validateResource checks a single resource.
+// prefix is prepended to every reported key.
+func validateResource(path, prefix string, r Resource) []configProblem {
+	var problems []configProblem
+
+	for _, e := range r.Exec.Validate() {
+		problems = append(problems, configProblem{File: path, Key: prefix + "exec." + e.Key, Err: e.Err})
+	}
+
+	for i, t := range r.Template {
+		for _, e := range t.Validate() {
+			problems = append(problems, configProblem{File: path, Key: fmt.Sprintf("%stemplate[%d].%s", prefix, i, e.Key), Err: e.Err})
+		}
+	}
+
+	for _, e := range r.Cache.Validate() {
+		problems = append(problems, configProblem{File: path, Key: prefix + e.Key, Err: e.Err})
+	}
+
+	for _, e := range r.Wait.Validate() {
+		problems = append(problems, configProblem{File: path, Key: prefix + e.Key, Err: e.Err})
+	}
+
+	if err := r.KeyCollision.Validate(); err != nil {
+		problems = append(problems, configProblem{File: path, Key: prefix + "key_collision", Err: err})
+	}
+
+	for _, e := range r.failurePolicy().Validate() {
+		problems = append(problems, configProblem{File: path, Key: prefix + e.Key, Err: e.Err})
+	}
+
+	if len(r.Backends.GetBackends()) == 0 {
+		problems = append(problems, configProblem{File: path, Key: prefix + "backend", Err: fmt.Errorf("no backend configured")})
+	}
+
+	return problems
+}
+
+// runValidate implements the validate command.
+// It returns the exit code.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	path := fs.String("config", defaultConfig, "path to the configuration file")
+	fs.Parse(args)
+
+	problems := validateConfiguration(*path)
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, p)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "found %d problem(s)\n", len(problems))
+		return 1
+	}
+	fmt.Println("configuration is valid")
+	return 0
+}
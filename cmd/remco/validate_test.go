@@ -0,0 +1,92 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+const (
+	invalidConfigFile string = `
+log_level = "debug"
+unknown_option = true
+
+[[resource]]
+  name = "broken"
+  on_failure = "crash"
+  [resource.exec]
+    kill_signal = "SIGFOO"
+  [[resource.template]]
+    src = "/tmp/remco-validate-missing.tmpl"
+    dst = "/tmp/remco-validate.cfg"
+    mode = "rw-r--r--"
+`
+)
+
+type ValidateSuite struct {
+	dir string
+}
+
+var _ = Suite(&ValidateSuite{})
+
+func (s *ValidateSuite) SetUpTest(t *C) {
+	s.dir = t.MkDir()
+}
+
+func (s *ValidateSuite) writeFile(t *C, name, content string) string {
+	fp := filepath.Join(s.dir, name)
+	err := ioutil.WriteFile(fp, []byte(content), 0644)
+	t.Assert(err, IsNil)
+	return fp
+}
+
+func (s *ValidateSuite) TestValidConfig(t *C) {
+	tmpl := s.writeFile(t, "test.tmpl", "{{ getv(\"/test\") }}")
+	resDir := filepath.Join(s.dir, "resource.d")
+	t.Assert(os.Mkdir(resDir, 0755), IsNil)
+	s.writeFile(t, "resource.d/res.toml", "[[template]]\nsrc = \""+tmpl+"\"\ndst = \"/tmp/remco-validate.cfg\"\n")
+	cfg := s.writeFile(t, "config.toml", "include_dir = \""+resDir+"\"\n[default_backends.mock]\nkeys = [\"/\"]\n")
+
+	t.Check(validateConfiguration(cfg), HasLen, 0)
+}
+
+func (s *ValidateSuite) TestInvalidConfig(t *C) {
+	cfg := s.writeFile(t, "config.toml", invalidConfigFile)
+
+	var keys []string
+	for _, p := range validateConfiguration(cfg) {
+		t.Check(p.File, Equals, cfg)
+		keys = append(keys, p.Key)
+	}
+	t.Check(keys, DeepEquals, []string{
+		"unknown_option",
+		"resource[0].exec.kill_signal",
+		"resource[0].template[0].src",
+		"resource[0].template[0].mode",
+		"resource[0].on_failure",
+		"resource[0].backend",
+	})
+}
+
+func (s *ValidateSuite) TestInvalidTemplate(t *C) {
+	tmpl := s.writeFile(t, "test.tmpl", "{% if %}")
+	resDir := filepath.Join(s.dir, "resource.d")
+	t.Assert(os.Mkdir(resDir, 0755), IsNil)
+	res := s.writeFile(t, "resource.d/res.toml", "[[template]]\nsrc = \""+tmpl+"\"\ndst = \"/tmp/remco-validate.cfg\"\n[backend.mock]\nkeys = [\"/\"]\n")
+	cfg := s.writeFile(t, "config.yml", "include_dir: "+resDir+"\n")
+
+	problems := validateConfiguration(cfg)
+	t.Assert(problems, HasLen, 1)
+	t.Check(problems[0].File, Equals, res)
+	t.Check(problems[0].Key, Equals, "template[0].src")
+}
@@ -0,0 +1,134 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/log"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+const defaultWatchConfigDebounce = time.Second
+
+// configWatcher watches the configuration file, the include_dir and the filter_dir for changes.
+// A value is sent on C once no further change was seen for the debounce window.
+type configWatcher struct {
+	C chan struct{}
+
+	watcher    *fsnotify.Watcher
+	configPath string
+	includeDir string
+	filterDir  string
+	debounce   time.Duration
+	done       chan struct{}
+}
+
+// newConfigWatcher creates a new configWatcher for the configuration file at path.
+// The directories to watch are taken from cfg.
+func newConfigWatcher(path string, cfg Configuration) (*configWatcher, error) {
+	debounce := defaultWatchConfigDebounce
+	if cfg.WatchConfigDebounce != "" {
+		var err error
+		debounce, err = time.ParseDuration(cfg.WatchConfigDebounce)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing watch_config_debounce failed")
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't create file watcher")
+	}
+
+	w := &configWatcher{
+		C:          make(chan struct{}, 1),
+		watcher:    watcher,
+		configPath: filepath.Clean(path),
+		debounce:   debounce,
+		done:       make(chan struct{}),
+	}
+
+	// watch the directory instead of the file, editors and config management tools
+	// often replace the file which would remove a watch on the file itself
+	dirs := []string{filepath.Dir(w.configPath)}
+	if cfg.IncludeDir != "" {
+		w.includeDir = filepath.Clean(cfg.IncludeDir)
+		dirs = append(dirs, w.includeDir)
+	}
+	if cfg.FilterDir != "" {
+		w.filterDir = filepath.Clean(cfg.FilterDir)
+		dirs = append(dirs, w.filterDir)
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, errors.Wrapf(err, "couldn't watch %s", dir)
+		}
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// relevant reports whether a change of the file at name requires a config reload.
+func (w *configWatcher) relevant(name string) bool {
+	name = filepath.Clean(name)
+	dir := filepath.Dir(name)
+	switch {
+	case name == w.configPath:
+		return true
+	case w.includeDir != "" && dir == w.includeDir:
+		return isConfigFile(name)
+	case w.filterDir != "" && dir == w.filterDir:
+		return strings.HasSuffix(name, ".js")
+	}
+	return false
+}
+
+func (w *configWatcher) run() {
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod || !w.relevant(event.Name) {
+				continue
+			}
+			log.WithFields("file", event.Name, "op", event.Op.String()).Debug("configuration changed")
+			timer.Reset(w.debounce)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error("file watcher error", "error", err)
+		case <-timer.C:
+			select {
+			case w.C <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Close stops watching.
+func (w *configWatcher) Close() {
+	close(w.done)
+	w.watcher.Close()
+}
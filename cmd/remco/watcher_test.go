@@ -0,0 +1,91 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type WatcherSuite struct {
+	dir        string
+	configPath string
+	includeDir string
+	filterDir  string
+	watcher    *configWatcher
+}
+
+var _ = Suite(&WatcherSuite{})
+
+func (s *WatcherSuite) SetUpTest(t *C) {
+	s.dir = t.MkDir()
+	s.configPath = filepath.Join(s.dir, "config")
+	s.includeDir = filepath.Join(s.dir, "resource.d")
+	s.filterDir = filepath.Join(s.dir, "filters")
+	t.Assert(os.Mkdir(s.includeDir, 0755), IsNil)
+	t.Assert(os.Mkdir(s.filterDir, 0755), IsNil)
+	t.Assert(ioutil.WriteFile(s.configPath, []byte(""), 0644), IsNil)
+
+	w, err := newConfigWatcher(s.configPath, Configuration{
+		IncludeDir:          s.includeDir,
+		FilterDir:           s.filterDir,
+		WatchConfig:         true,
+		WatchConfigDebounce: "50ms",
+	})
+	t.Assert(err, IsNil)
+	s.watcher = w
+}
+
+func (s *WatcherSuite) TearDownTest(t *C) {
+	s.watcher.Close()
+}
+
+func (s *WatcherSuite) expectReload(t *C, expected bool) {
+	select {
+	case <-s.watcher.C:
+		t.Check(expected, Equals, true, Commentf("unexpected reload"))
+	case <-time.After(500 * time.Millisecond):
+		t.Check(expected, Equals, false, Commentf("missing reload"))
+	}
+}
+
+func (s *WatcherSuite) TestConfigChange(t *C) {
+	t.Assert(ioutil.WriteFile(s.configPath, []byte("log_level = \"debug\""), 0644), IsNil)
+	s.expectReload(t, true)
+}
+
+func (s *WatcherSuite) TestIncludeDirChange(t *C) {
+	// several changes are merged into a single reload
+	for _, name := range []string{"a.toml", "b.yml", "c.json"} {
+		t.Assert(ioutil.WriteFile(filepath.Join(s.includeDir, name), []byte(""), 0644), IsNil)
+	}
+	s.expectReload(t, true)
+	s.expectReload(t, false)
+}
+
+func (s *WatcherSuite) TestFilterDirChange(t *C) {
+	t.Assert(ioutil.WriteFile(filepath.Join(s.filterDir, "reverse.js"), []byte("In"), 0644), IsNil)
+	s.expectReload(t, true)
+}
+
+func (s *WatcherSuite) TestIrrelevantChange(t *C) {
+	t.Assert(ioutil.WriteFile(filepath.Join(s.dir, "other"), []byte(""), 0644), IsNil)
+	t.Assert(ioutil.WriteFile(filepath.Join(s.includeDir, "resource.toml.swp"), []byte(""), 0644), IsNil)
+	t.Assert(ioutil.WriteFile(filepath.Join(s.filterDir, "reverse.js.swp"), []byte(""), 0644), IsNil)
+	s.expectReload(t, false)
+}
+
+func (s *WatcherSuite) TestInvalidDebounce(t *C) {
+	_, err := newConfigWatcher(s.configPath, Configuration{WatchConfigDebounce: "soon"})
+	t.Check(err, NotNil)
+}
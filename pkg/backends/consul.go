@@ -44,7 +44,9 @@ func (c *ConsulConfig) Connect() (template.Backend, error) {
 	if c == nil {
 		return template.Backend{}, berr.ErrNilConfig
 	}
-	c.Backend.Name = "consul"
+	if c.Backend.Name == "" {
+		c.Backend.Name = "consul"
+	}
 
 	// No nodes are set but a SRVRecord is provided
 	if len(c.Nodes) == 0 && c.SRVRecord != "" {
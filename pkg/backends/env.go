@@ -24,7 +24,9 @@ func (c *EnvConfig) Connect() (template.Backend, error) {
 	if c == nil {
 		return template.Backend{}, berr.ErrNilConfig
 	}
-	c.Backend.Name = "env"
+	if c.Backend.Name == "" {
+		c.Backend.Name = "env"
+	}
 
 	client, err := env.New()
 	if err != nil {
@@ -62,10 +62,12 @@ func (c *EtcdConfig) Connect() (template.Backend, error) {
 		c.Version = 2
 	}
 
-	if c.Version == 3 {
-		c.Backend.Name = "etcdv3"
-	} else {
-		c.Backend.Name = "etcd"
+	if c.Backend.Name == "" {
+		if c.Version == 3 {
+			c.Backend.Name = "etcdv3"
+		} else {
+			c.Backend.Name = "etcd"
+		}
 	}
 
 	// No nodes are set but a SRVRecord is provided
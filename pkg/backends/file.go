@@ -32,7 +32,9 @@ func (c *FileConfig) Connect() (template.Backend, error) {
 		return template.Backend{}, berr.ErrNilConfig
 	}
 
-	c.Backend.Name = "file"
+	if c.Backend.Name == "" {
+		c.Backend.Name = "file"
+	}
 	log.WithFields(
 		"backend", c.Backend.Name,
 		"filepath", c.Filepath,
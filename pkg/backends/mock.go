@@ -25,7 +25,9 @@ func (c *MockConfig) Connect() (template.Backend, error) {
 	if c == nil {
 		return template.Backend{}, berr.ErrNilConfig
 	}
-	c.Backend.Name = "mock"
+	if c.Backend.Name == "" {
+		c.Backend.Name = "mock"
+	}
 	client, err := mock.New(c.Error, make(map[string]string))
 	if err != nil {
 		return c.Backend, err
@@ -44,7 +44,9 @@ func (c *NatsConfig) Connect() (template.Backend, error) {
 		return template.Backend{}, berr.ErrNilConfig
 	}
 
-	c.Backend.Name = "nats"
+	if c.Backend.Name == "" {
+		c.Backend.Name = "nats"
+	}
 
 	log.WithFields(
 		"backend", c.Backend.Name,
@@ -39,7 +39,9 @@ func (p *Plugin) Connect() (template.Backend, error) {
 		return template.Backend{}, berr.ErrNilConfig
 	}
 
-	p.Backend.Name = path.Base(p.Path)
+	if p.Backend.Name == "" {
+		p.Backend.Name = path.Base(p.Path)
+	}
 
 	client, err := pie.StartProviderCodec(jsonrpc.NewClientCodec, os.Stderr, p.Path)
 	if err != nil {
@@ -38,7 +38,9 @@ func (c *RedisConfig) Connect() (template.Backend, error) {
 		return template.Backend{}, berr.ErrNilConfig
 	}
 
-	c.Backend.Name = "redis"
+	if c.Backend.Name == "" {
+		c.Backend.Name = "redis"
+	}
 
 	// No nodes are set but a SRVRecord is provided
 	if len(c.Nodes) == 0 && c.SRVRecord != "" {
@@ -58,7 +58,9 @@ func (c *VaultConfig) Connect() (template.Backend, error) {
 		return template.Backend{}, berr.ErrNilConfig
 	}
 
-	c.Backend.Name = "vault"
+	if c.Backend.Name == "" {
+		c.Backend.Name = "vault"
+	}
 	log.WithFields(
 		"backend", c.Backend.Name,
 		"nodes", []string{c.Node},
@@ -31,7 +31,9 @@ func (c *ZookeeperConfig) Connect() (template.Backend, error) {
 		return template.Backend{}, berr.ErrNilConfig
 	}
 
-	c.Backend.Name = "zookeeper"
+	if c.Backend.Name == "" {
+		c.Backend.Name = "zookeeper"
+	}
 
 	// No nodes are set but a SRVRecord is provided
 	if len(c.Nodes) == 0 && c.SRVRecord != "" {
@@ -0,0 +1,128 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package child
+
+import (
+	"fmt"
+	"os/exec"
+	"os/user"
+	"sort"
+	"strconv"
+)
+
+// The names of the supported resource limits.
+const (
+	// RlimitNoFile is the maximum number of open files.
+	RlimitNoFile = "nofile"
+	// RlimitNProc is the maximum number of processes of the user.
+	RlimitNProc = "nproc"
+	// RlimitCore is the maximum size of a core dump in bytes.
+	RlimitCore = "core"
+)
+
+// Attr holds the attributes of a process.
+// The zero value starts the process like remco itself.
+type Attr struct {
+	// Credential is the user and the groups the process runs as.
+	Credential *Credential
+
+	// Dir is the working directory of the process.
+	Dir string
+
+	// Umask is the umask of the process.
+	Umask *int
+
+	// Rlimits maps the names of resource limits to their value.
+	// The soft and the hard limit are set to the same value.
+	Rlimits map[string]uint64
+
+	// Setpgid starts the process in a new process group, so that KillGroup kills the process and all its children.
+	Setpgid bool
+}
+
+// Credential is the user and the groups a process runs as.
+type Credential struct {
+	Uid    uint32
+	Gid    uint32
+	Groups []uint32
+}
+
+// LookupCredential returns the credential of the user name or uid.
+// The process runs with the primary group of the user, unless group is set to a group name or gid.
+// The supplementary groups of the user are added.
+func LookupCredential(userName, group string) (*Credential, error) {
+	u, err := user.Lookup(userName)
+	if err != nil {
+		if u, err = user.LookupId(userName); err != nil {
+			return nil, fmt.Errorf("unknown user %q", userName)
+		}
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %q of user %q", u.Uid, userName)
+	}
+	gid := u.Gid
+	if group != "" {
+		if gid, err = lookupGroup(group); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &Credential{Uid: uint32(uid)}
+	id, err := strconv.ParseUint(gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %q", gid)
+	}
+	c.Gid = uint32(id)
+
+	// the supplementary groups are optional, e.g. they can't be looked up without cgo on some systems
+	groups, _ := u.GroupIds()
+	for _, g := range groups {
+		if id, err := strconv.ParseUint(g, 10, 32); err == nil {
+			c.Groups = append(c.Groups, uint32(id))
+		}
+	}
+	return c, nil
+}
+
+// lookupGroup returns the gid of the group name or gid.
+func lookupGroup(group string) (string, error) {
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		if g, err = user.LookupGroupId(group); err != nil {
+			return "", fmt.Errorf("unknown group %q", group)
+		}
+	}
+	return g.Gid, nil
+}
+
+// ValidateRlimits returns an error if one of the resource limits is unknown.
+func ValidateRlimits(rlimits map[string]uint64) error {
+	names := make([]string, 0, len(rlimits))
+	for name := range rlimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch name {
+		case RlimitNoFile, RlimitNProc, RlimitCore:
+		default:
+			return fmt.Errorf("unknown resource limit %q - valid limits are %q, %q and %q", name, RlimitNoFile, RlimitNProc, RlimitCore)
+		}
+	}
+	return nil
+}
+
+// Start starts the command cmd with the attributes.
+func (a Attr) Start(cmd *exec.Cmd) error {
+	if err := a.Apply(cmd); err != nil {
+		return err
+	}
+	return a.startCmd(cmd)
+}
@@ -0,0 +1,145 @@
+// +build linux
+
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package child
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// the test binary is the helper that sets the umask and the resource limits
+	RunHelper()
+	os.Exit(m.Run())
+}
+
+func runChild(t *testing.T, attr Attr, script string) string {
+	var out bytes.Buffer
+	c, err := New(&NewInput{
+		Stdout:      &out,
+		Stderr:      &out,
+		Command:     "/bin/sh",
+		Args:        []string{"-c", script},
+		Attr:        attr,
+		KillTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case code := <-c.ExitCh():
+		if code != 0 {
+			t.Errorf("unexpected exit code %d: %s", code, out.String())
+		}
+	case <-time.After(5 * time.Second):
+		c.StopImmediately()
+		t.Fatal("the child didn't exit")
+	}
+	return strings.TrimSpace(out.String())
+}
+
+func TestAttr(t *testing.T) {
+	dir := t.TempDir()
+	umask := 027
+	out := runChild(t, Attr{
+		Dir:     dir,
+		Umask:   &umask,
+		Rlimits: map[string]uint64{RlimitNoFile: 512, RlimitCore: 0},
+	}, "sleep 0.2; pwd; umask; ulimit -n; ulimit -c")
+	expected := fmt.Sprintf("%s\n0027\n512\n0", dir)
+	if out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+
+	// the umask of the process that started the child is unchanged
+	own := syscall.Umask(022)
+	syscall.Umask(own)
+	if own == umask {
+		t.Error("the umask of the child shouldn't be set in the parent process")
+	}
+}
+
+func TestCredential(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("only root can change the user")
+	}
+	cred, err := LookupCredential("nobody", "")
+	if err != nil {
+		t.Skip(err)
+	}
+	out := runChild(t, Attr{Credential: cred}, "id -u; id -g")
+	expected := fmt.Sprintf("%d\n%d", cred.Uid, cred.Gid)
+	if out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+
+	// the helper sets the limits before it switches to the user
+	var nofile syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &nofile); err != nil {
+		t.Fatal(err)
+	}
+	umask := 077
+	out = runChild(t, Attr{Credential: cred, Umask: &umask, Rlimits: map[string]uint64{RlimitNoFile: nofile.Max}}, "id -u; umask; ulimit -Hn")
+	expected = fmt.Sprintf("%d\n0077\n%d", cred.Uid, nofile.Max)
+	if out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+
+	if _, err := LookupCredential("no-such-user", ""); err == nil {
+		t.Error("an unknown user should fail")
+	}
+	if _, err := LookupCredential("nobody", "no-such-group"); err == nil {
+		t.Error("an unknown group should fail")
+	}
+}
+
+func TestValidateRlimits(t *testing.T) {
+	if err := ValidateRlimits(map[string]uint64{RlimitNProc: 10}); err != nil {
+		t.Error(err)
+	}
+	if err := ValidateRlimits(map[string]uint64{"stack": 10}); err == nil {
+		t.Error("an unknown limit should fail")
+	}
+}
+
+func TestPidKeepsExitCode(t *testing.T) {
+	c, err := New(&NewInput{
+		Command:     "/bin/sh",
+		Args:        []string{"-c", "exit 3"},
+		KillTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.DoneCh():
+	case <-time.After(5 * time.Second):
+		c.StopImmediately()
+		t.Fatal("the child didn't exit")
+	}
+	if pid := c.Pid(); pid != 0 {
+		t.Errorf("expected no pid after the exit, got %d", pid)
+	}
+	if code := <-c.ExitCh(); code != 3 {
+		t.Errorf("expected the exit code 3, got %d", code)
+	}
+}
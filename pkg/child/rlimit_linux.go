@@ -0,0 +1,34 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package child
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// rlimitsSupported reports whether resource limits can be set on this platform.
+const rlimitsSupported = true
+
+var rlimitResources = map[string]int{
+	RlimitNoFile: unix.RLIMIT_NOFILE,
+	RlimitNProc:  unix.RLIMIT_NPROC,
+	RlimitCore:   unix.RLIMIT_CORE,
+}
+
+// setRlimit sets the resource limit name of the current process, it is called by the helper before the child is executed.
+// syscall.Setrlimit is used to keep the Go runtime from restoring its own nofile limit on exec.
+func setRlimit(name string, v uint64) error {
+	resource, ok := rlimitResources[name]
+	if !ok {
+		return ValidateRlimits(map[string]uint64{name: v})
+	}
+	return syscall.Setrlimit(resource, &syscall.Rlimit{Cur: v, Max: v})
+}
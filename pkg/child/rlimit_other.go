@@ -0,0 +1,23 @@
+// +build !linux,!windows
+
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package child
+
+import (
+	"fmt"
+)
+
+// rlimitsSupported reports whether resource limits can be set on this platform, they are only supported on linux.
+const rlimitsSupported = false
+
+// setRlimit returns an error, resource limits are only supported on linux.
+func setRlimit(name string, v uint64) error {
+	return fmt.Errorf("rlimits are only supported on linux")
+}
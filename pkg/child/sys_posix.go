@@ -0,0 +1,175 @@
+// +build !windows
+
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package child
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// helperArg is the first argument of remco when it runs as the helper that prepares a child process.
+const helperArg = "__remco_child_helper"
+
+// Apply sets the credential, the working directory and the process group of the command cmd.
+func (a Attr) Apply(cmd *exec.Cmd) error {
+	cmd.Dir = a.Dir
+	if a.Credential == nil && !a.Setpgid {
+		return nil
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: a.Setpgid}
+	if a.Credential != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid:    a.Credential.Uid,
+			Gid:    a.Credential.Gid,
+			Groups: a.Credential.Groups,
+			// only root can set the supplementary groups
+			NoSetGroups: os.Getuid() != 0,
+		}
+	}
+	return nil
+}
+
+// KillGroup kills the process group of the process p, which was started with Setpgid.
+func KillGroup(p *os.Process) error {
+	if err := syscall.Kill(-p.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	return nil
+}
+
+// startCmd starts the command cmd.
+// A umask and resource limits are set by a helper process that executes the command afterwards,
+// so they apply to the child process only and not to remco itself.
+// The helper also switches to the credential after the limits were set, so that root can raise them.
+func (a Attr) startCmd(cmd *exec.Cmd) error {
+	if a.Umask == nil && len(a.Rlimits) == 0 {
+		return cmd.Start()
+	}
+	if len(a.Rlimits) > 0 && !rlimitsSupported {
+		return fmt.Errorf("rlimits are only supported on linux")
+	}
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "failed to find the remco executable")
+	}
+
+	args := []string{exe, helperArg}
+	if a.Umask != nil {
+		args = append(args, fmt.Sprintf("umask=%o", *a.Umask))
+	}
+	for name, v := range a.Rlimits {
+		args = append(args, fmt.Sprintf("%s=%d", name, v))
+	}
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Credential != nil {
+		cred := cmd.SysProcAttr.Credential
+		args = append(args, fmt.Sprintf("uid=%d", cred.Uid), fmt.Sprintf("gid=%d", cred.Gid))
+		if !cred.NoSetGroups {
+			groups := make([]string, len(cred.Groups))
+			for i, g := range cred.Groups {
+				groups[i] = strconv.FormatUint(uint64(g), 10)
+			}
+			args = append(args, "groups="+strings.Join(groups, ","))
+		}
+		cmd.SysProcAttr.Credential = nil
+	}
+	cmd.Args = append(append(args, "--", cmd.Path), cmd.Args...)
+	cmd.Path = exe
+	return cmd.Start()
+}
+
+// RunHelper runs the helper that sets the umask, the resource limits and the credential of a child process
+// and executes the child afterwards. It must be called at the start of main.
+// RunHelper returns immediately if the process wasn't started as a helper and never returns otherwise.
+func RunHelper() {
+	if len(os.Args) < 2 || os.Args[1] != helperArg {
+		return
+	}
+	if err := runHelper(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "remco: starting the child process failed: %v\n", err)
+		os.Exit(ExitCodeError)
+	}
+}
+
+// runHelper applies the attributes in args and executes the command that follows "--".
+func runHelper(args []string) error {
+	uid, gid := -1, -1
+	var groups []int
+	for ; len(args) > 0 && args[0] != "--"; args = args[1:] {
+		name, value, _ := strings.Cut(args[0], "=")
+		switch name {
+		case "umask":
+			umask, err := strconv.ParseUint(value, 8, 32)
+			if err != nil {
+				return fmt.Errorf("invalid umask %q", value)
+			}
+			syscall.Umask(int(umask))
+		case "uid", "gid":
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", name, value)
+			}
+			if name == "uid" {
+				uid = id
+			} else {
+				gid = id
+			}
+		case "groups":
+			groups = []int{}
+			for _, g := range strings.Split(value, ",") {
+				if g == "" {
+					continue
+				}
+				id, err := strconv.Atoi(g)
+				if err != nil {
+					return fmt.Errorf("invalid group %q", g)
+				}
+				groups = append(groups, id)
+			}
+		default:
+			v, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid resource limit %q", args[0])
+			}
+			if err := setRlimit(name, v); err != nil {
+				return errors.Wrapf(err, "setting the resource limit %s failed", name)
+			}
+		}
+	}
+	if len(args) < 3 {
+		return ErrMissingCommand
+	}
+
+	if groups != nil {
+		if err := syscall.Setgroups(groups); err != nil {
+			return errors.Wrap(err, "setting the groups failed")
+		}
+	}
+	if gid >= 0 {
+		if err := syscall.Setgid(gid); err != nil {
+			return errors.Wrap(err, "setting the group failed")
+		}
+	}
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return errors.Wrap(err, "setting the user failed")
+		}
+	}
+	return syscall.Exec(args[1], args[2:], os.Environ())
+}
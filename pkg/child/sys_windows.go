@@ -0,0 +1,39 @@
+// +build windows
+
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package child
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// Apply sets the working directory of the command cmd.
+// Credentials, a umask, resource limits and process groups are not supported on windows.
+func (a Attr) Apply(cmd *exec.Cmd) error {
+	if a.Credential != nil || a.Umask != nil || len(a.Rlimits) > 0 {
+		return fmt.Errorf("user, group, umask and rlimits are not supported on windows")
+	}
+	cmd.Dir = a.Dir
+	return nil
+}
+
+// KillGroup kills the process p, process groups are not supported on windows.
+func KillGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+func (a Attr) startCmd(cmd *exec.Cmd) error {
+	return cmd.Start()
+}
+
+// RunHelper does nothing, the helper is only used for a umask and resource limits which are not supported on windows.
+func RunHelper() {}
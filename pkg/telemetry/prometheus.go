@@ -26,6 +26,9 @@ type PrometheusSink struct {
 	Addr       string
 	Expiration int
 
+	// Handlers are served on the stats endpoint in addition to /metrics.
+	Handlers map[string]http.Handler `toml:"-" json:"-"`
+
 	httpServer     *http.Server
 	prometheusSink *metricsPrometheus.PrometheusSink
 }
@@ -38,6 +41,9 @@ func (p *PrometheusSink) Init() (metrics.MetricSink, error) {
 
 	handler := http.NewServeMux()
 	handler.Handle("/metrics", promhttp.Handler())
+	for pattern, h := range p.Handlers {
+		handler.Handle(pattern, h)
+	}
 	p.httpServer = &http.Server{Addr: p.Addr, Handler: handler}
 
 	go func() {
@@ -33,7 +33,8 @@ type Backend struct {
 	easykv.ReadWatcher
 
 	// Name is the name of the backend for example etcd or consul.
-	// The name is attached to the logs.
+	// The name is attached to the logs and metrics.
+	// It defaults to the backend type and should be set if a resource uses the same backend type more than once.
 	Name string
 
 	// Onetime - render the config file and quit.
@@ -54,46 +55,127 @@ type Backend struct {
 	// The backend keys that the template requires to be rendered correctly.
 	Keys []string
 
+	// Mount is an optional path under which the keys of this backend are available in the templates.
+	// For example with mount = "/vault" the key "/db/pass" is available as "/vault/db/pass".
+	Mount string
+
+	// Priority orders the backends if the resource uses the "priority" key collision policy.
+	// The value of the backend with the highest priority wins.
+	Priority int
+
 	store *memkv.Store
+	// index is the position of the backend in its resource.
+	// It separates the cache files of backends with the same name.
+	index int
+	// cached is true if the backend serves the data of the on-disk cache.
+	cached bool
+}
+
+// liveBackend is a backend that was connected after the resource started with its cached data.
+type liveBackend struct {
+	// index is the index of the cached backend in the backend list of the resource.
+	index   int
+	backend Backend
+}
+
+// connectBackend connects to a single backend.
+// It retries every 2 seconds until a connection has been established or the context is canceled.
+// Onetime backends are not retried.
+func connectBackend(ctx context.Context, config BackendConnector) (Backend, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return Backend{}, err
+		}
+		b, err := config.Connect()
+		if err == nil || err == berr.ErrNilConfig {
+			return b, err
+		}
+		log.WithFields(
+			"backend", b.Name,
+			"error", err,
+		).Error("connect failed")
+
+		if config.GetBackend().Onetime {
+			return b, err
+		}
+		//try again after 2 seconds
+		select {
+		case <-ctx.Done():
+		case <-time.After(2 * time.Second):
+		}
+	}
 }
 
 // connectAllBackends connects to all configured backends.
 // This method blocks until a connection to every backend has been established or the context is canceled.
-func connectAllBackends(ctx context.Context, bc []BackendConnector) ([]Backend, error) {
+//
+// If a cache is given, connectAllBackends waits only cache.timeout for every backend.
+// A backend that can't be reached in time is replaced by its cached data and connected in the background.
+// The connected backend is sent to liveChan.
+func connectAllBackends(ctx context.Context, bc []BackendConnector, cache *backendCache, liveChan chan<- liveBackend) ([]Backend, error) {
 	var backendList []Backend
 	for _, config := range bc {
-	retryloop:
-		for {
-			select {
-			case <-ctx.Done():
-				for _, be := range backendList {
-					be.Close()
+		cctx, cancel := ctx, context.CancelFunc(func() {})
+		if cache != nil {
+			cctx, cancel = context.WithTimeout(ctx, cache.timeout)
+		}
+		b, err := connectBackend(cctx, config)
+		cancel()
+		index := len(backendList)
+
+		if err != nil && ctx.Err() == nil && cache != nil && err != berr.ErrNilConfig {
+			var cerr error
+			cfg := *config.GetBackend()
+			b.index, cfg.index = index, index
+			b, cerr = cache.backend(b, cfg)
+			if cerr == nil {
+				log.WithFields(
+					"backend", b.Name,
+				).Warn("backend not reachable, using the cached data")
+				if !b.Onetime {
+					go reconnectBackend(ctx, len(backendList), config, liveChan)
 				}
-				return backendList, ctx.Err()
-			default:
-				b, err := config.Connect()
-				if err == nil {
-					backendList = append(backendList, b)
-				} else if err != berr.ErrNilConfig {
-					log.WithFields(
-						"backend", b.Name,
-						"error", err,
-					).Error("connect failed")
-
-					//try again after 2 seconds to watch
-					if config.GetBackend().Onetime != true {
-						time.Sleep(2 * time.Second)
-						continue retryloop
-					}
+				err = nil
+			} else {
+				log.WithFields(
+					"backend", b.Name,
+					"error", cerr,
+				).Warn("no cached data")
+				if !config.GetBackend().Onetime {
+					b, err = connectBackend(ctx, config)
 				}
-				break retryloop
 			}
 		}
+
+		if ctx.Err() != nil {
+			for _, be := range backendList {
+				be.Close()
+			}
+			return backendList, ctx.Err()
+		}
+		if err == nil {
+			b.index = index
+			backendList = append(backendList, b)
+		}
 	}
 
 	return backendList, nil
 }
 
+// reconnectBackend connects to the backend in the background and sends it to liveChan.
+func reconnectBackend(ctx context.Context, index int, config BackendConnector, liveChan chan<- liveBackend) {
+	b, err := connectBackend(ctx, config)
+	if err != nil {
+		return
+	}
+	b.index = index
+	select {
+	case liveChan <- liveBackend{index: index, backend: b}:
+	case <-ctx.Done():
+		b.Close()
+	}
+}
+
 func (s Backend) watch(ctx context.Context, processChan chan Backend, errChan chan berr.BackendError) {
 	if s.Onetime {
 		return
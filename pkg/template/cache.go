@@ -0,0 +1,273 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+	"github.com/pkg/errors"
+)
+
+// defaultCacheTimeout is the default time to wait for a backend connection before the cache is used.
+const defaultCacheTimeout = 10 * time.Second
+
+// CacheConfig is the configuration of the on-disk cache of the backend data.
+// The data of every backend is saved after every successful retrieval.
+// If a backend can't be reached on startup, the templates are rendered with the cached data.
+type CacheConfig struct {
+	// Dir enables the cache. The cached data is saved in this directory.
+	Dir string `json:"dir"`
+
+	// KeyFile is an optional file with a secret to encrypt the cached data.
+	KeyFile string `toml:"key_file" json:"key_file"`
+
+	// Timeout is the time to wait for a backend connection on startup before the cache is used.
+	// Defaults to 10s.
+	Timeout string `json:"timeout"`
+}
+
+// Validate checks the cache configuration.
+func (c CacheConfig) Validate() []ConfigError {
+	var errs []ConfigError
+	if c.Timeout != "" {
+		if _, err := time.ParseDuration(c.Timeout); err != nil {
+			errs = append(errs, ConfigError{Key: "cache.timeout", Err: err})
+		}
+	}
+	if c.KeyFile != "" {
+		if _, err := readCacheKey(c.KeyFile); err != nil {
+			errs = append(errs, ConfigError{Key: "cache.key_file", Err: err})
+		}
+	}
+	return errs
+}
+
+// backendCache reads and writes the cached data of the backends of a resource.
+type backendCache struct {
+	dir     string
+	key     []byte
+	timeout time.Duration
+
+	// hashes holds the hash of the last written data per file, to skip unchanged data.
+	hashes map[string][sha256.Size]byte
+}
+
+// newBackendCache creates a backendCache for the resource with the given name.
+// It returns nil if the cache is not enabled.
+func newBackendCache(c CacheConfig, resourceName string) (*backendCache, error) {
+	if c.Dir == "" {
+		return nil, nil
+	}
+	bc := &backendCache{
+		dir:     filepath.Join(c.Dir, sanitizeFileName(resourceName)),
+		timeout: defaultCacheTimeout,
+		hashes:  make(map[string][sha256.Size]byte),
+	}
+	if c.Timeout != "" {
+		var err error
+		bc.timeout, err = time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing cache.timeout failed")
+		}
+	}
+	if c.KeyFile != "" {
+		key, err := readCacheKey(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		bc.key = key
+	}
+	return bc, nil
+}
+
+// readCacheKey derives the AES-256 key from the content of the key file.
+func readCacheKey(path string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read the cache key file")
+	}
+	buf = bytes.TrimSpace(buf)
+	if len(buf) == 0 {
+		return nil, fmt.Errorf("the cache key file %s is empty", path)
+	}
+	key := sha256.Sum256(buf)
+	return key[:], nil
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+func sanitizeFileName(name string) string {
+	return unsafeFileNameChars.ReplaceAllString(name, "_")
+}
+
+// path returns the path of the cache file of the backend.
+// The file name contains a hash of the backend settings that change the retrieved data,
+// so that a changed configuration never uses stale data. The index of the backend separates
+// backends of the same type and name, e.g. two etcd clusters with the same keys.
+func (c *backendCache) path(b Backend) string {
+	h := sha256.Sum256([]byte(strings.Join(append([]string{b.Name, strconv.Itoa(b.index), b.Prefix}, b.Keys...), "\x00")))
+	return filepath.Join(c.dir, fmt.Sprintf("%s-%x.cache", sanitizeFileName(b.Name), h[:6]))
+}
+
+// backend returns a copy of the backend b that serves the cached data.
+// The backend configuration config is used if b is empty, e.g. because the connection attempt was canceled.
+func (c *backendCache) backend(b, config Backend) (Backend, error) {
+	if b.Name == "" {
+		b = config
+	}
+	data, err := c.load(b)
+	if err != nil {
+		return b, err
+	}
+	b.ReadWatcher = &cachedClient{data: data}
+	b.cached = true
+	return b, nil
+}
+
+// save writes the data of the backend to the cache.
+// The file is replaced atomically.
+func (c *backendCache) save(b Backend, data map[string]string) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "json marshal failed")
+	}
+	fp := c.path(b)
+	h := sha256.Sum256(buf)
+	if last, ok := c.hashes[fp]; ok && last == h {
+		return nil
+	}
+
+	if c.key != nil {
+		if buf, err = encrypt(c.key, buf); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return errors.Wrap(err, "couldn't create the cache directory")
+	}
+	temp, err := ioutil.TempFile(c.dir, "."+filepath.Base(fp))
+	if err != nil {
+		return errors.Wrap(err, "couldn't create temp file")
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(buf); err != nil {
+		temp.Close()
+		return errors.Wrap(err, "couldn't write temp file")
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return errors.Wrap(err, "couldn't sync temp file")
+	}
+	if err := temp.Close(); err != nil {
+		return errors.Wrap(err, "couldn't close temp file")
+	}
+	if err := os.Rename(temp.Name(), fp); err != nil {
+		return errors.Wrap(err, "couldn't rename temp file")
+	}
+	c.hashes[fp] = h
+	return nil
+}
+
+// load reads the cached data of the backend.
+func (c *backendCache) load(b Backend) (map[string]string, error) {
+	buf, err := ioutil.ReadFile(c.path(b))
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read the cache file")
+	}
+	if c.key != nil {
+		if buf, err = decrypt(c.key, buf); err != nil {
+			return nil, err
+		}
+	}
+	var data map[string]string
+	if err := json.Unmarshal(buf, &data); err != nil {
+		return nil, errors.Wrap(err, "json unmarshal failed")
+	}
+	return data, nil
+}
+
+func encrypt(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating the cipher failed")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating the cipher failed")
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "creating the nonce failed")
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decrypt(key, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating the cipher failed")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating the cipher failed")
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, fmt.Errorf("the cache file is too short")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypting the cache file failed")
+	}
+	return plaintext, nil
+}
+
+// cachedClient is a easykv.ReadWatcher that serves the cached data of a backend.
+type cachedClient struct {
+	data map[string]string
+}
+
+// GetValues returns all cached values with one of the given key prefixes.
+func (c *cachedClient) GetValues(keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for k, v := range c.data {
+		for _, key := range keys {
+			if strings.HasPrefix(k, key) {
+				vars[k] = v
+				break
+			}
+		}
+	}
+	return vars, nil
+}
+
+// WatchPrefix blocks until the context is canceled, the cached data never changes.
+func (c *cachedClient) WatchPrefix(ctx context.Context, prefix string, opts ...easykv.WatchOption) (uint64, error) {
+	<-ctx.Done()
+	return 0, easykv.ErrWatchCanceled
+}
+
+// Close is a no-op.
+func (c *cachedClient) Close() {}
@@ -0,0 +1,170 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/HeavyHorst/easykv/mock"
+	. "gopkg.in/check.v1"
+)
+
+// flakyConnector fails to connect until down is set to 0.
+type flakyConnector struct {
+	down    int32
+	backend Backend
+	data    map[string]string
+}
+
+func (c *flakyConnector) Connect() (Backend, error) {
+	if atomic.LoadInt32(&c.down) == 1 {
+		return c.backend, fmt.Errorf("connection refused")
+	}
+	b := c.backend
+	b.ReadWatcher, _ = mock.New(nil, c.data)
+	return b, nil
+}
+
+func (c *flakyConnector) GetBackend() *Backend {
+	return &c.backend
+}
+
+type CacheSuite struct{}
+
+var _ = Suite(&CacheSuite{})
+
+func (s *CacheSuite) TestSaveAndLoad(t *C) {
+	dir := t.MkDir()
+	keyFile := filepath.Join(dir, "key")
+	t.Assert(ioutil.WriteFile(keyFile, []byte("secret\n"), 0600), IsNil)
+
+	cache, err := newBackendCache(CacheConfig{Dir: dir, KeyFile: keyFile}, "res/1")
+	t.Assert(err, IsNil)
+	b := Backend{Name: "etcd", Keys: []string{"/app"}}
+	data := map[string]string{"/app/password": "hunter2"}
+	t.Assert(cache.save(b, data), IsNil)
+
+	// the file is encrypted
+	buf, err := ioutil.ReadFile(cache.path(b))
+	t.Assert(err, IsNil)
+	t.Check(string(buf), Not(Matches), "(?s).*hunter2.*")
+
+	loaded, err := cache.load(b)
+	t.Assert(err, IsNil)
+	t.Check(loaded, DeepEquals, data)
+
+	// other keys use another file
+	_, err = cache.load(Backend{Name: "etcd", Keys: []string{"/other"}})
+	t.Check(err, NotNil)
+
+	// a wrong key can't decrypt the data
+	t.Assert(ioutil.WriteFile(keyFile, []byte("other"), 0600), IsNil)
+	cache, err = newBackendCache(CacheConfig{Dir: dir, KeyFile: keyFile}, "res/1")
+	t.Assert(err, IsNil)
+	_, err = cache.load(b)
+	t.Check(err, ErrorMatches, "decrypting the cache file failed.*")
+}
+
+func (s *CacheSuite) TestSameNamedBackends(t *C) {
+	dir := t.MkDir()
+	first := &flakyConnector{
+		backend: Backend{Name: "etcd", Keys: []string{"/app"}},
+		data:    map[string]string{"/app/cluster": "first"},
+	}
+	second := &flakyConnector{
+		backend: Backend{Name: "etcd", Keys: []string{"/app"}},
+		data:    map[string]string{"/app/cluster": "second"},
+	}
+	connectors := []BackendConnector{first, second}
+	cache, err := newBackendCache(CacheConfig{Dir: dir, Timeout: "10ms"}, "clusters")
+	t.Assert(err, IsNil)
+
+	// cache the data of both clusters
+	backends, err := connectAllBackends(context.Background(), connectors, cache, nil)
+	t.Assert(err, IsNil)
+	t.Assert(backends, HasLen, 2)
+	for i, b := range backends {
+		data, err := b.GetValues(b.Keys)
+		t.Assert(err, IsNil)
+		t.Assert(cache.save(b, data), IsNil)
+		b.Close()
+		t.Check(b.index, Equals, i)
+	}
+	t.Check(cache.path(backends[0]), Not(Equals), cache.path(backends[1]))
+
+	// every cluster gets its own data back during an outage
+	first.down, second.down = 1, 1
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backends, err = connectAllBackends(ctx, connectors, cache, make(chan liveBackend, 2))
+	t.Assert(err, IsNil)
+	t.Assert(backends, HasLen, 2)
+	for i, expected := range []string{"first", "second"} {
+		t.Check(backends[i].cached, Equals, true)
+		data, err := backends[i].GetValues(backends[i].Keys)
+		t.Assert(err, IsNil)
+		t.Check(data["/app/cluster"], Equals, expected)
+	}
+}
+
+func (s *CacheSuite) TestCacheConfigValidate(t *C) {
+	t.Check(CacheConfig{Timeout: "soon"}.Validate(), HasLen, 1)
+	t.Check(CacheConfig{KeyFile: "/nonexistent/key"}.Validate(), HasLen, 1)
+	t.Check(CacheConfig{Dir: "/var/cache/remco", Timeout: "5s"}.Validate(), HasLen, 0)
+}
+
+func (s *CacheSuite) TestColdStartFromCache(t *C) {
+	dir := t.MkDir()
+	tmpl := filepath.Join(dir, "tmpl")
+	dst := filepath.Join(dir, "dst")
+	t.Assert(ioutil.WriteFile(tmpl, []byte(`{{ getv("/key") }}`), 0644), IsNil)
+
+	connector := &flakyConnector{
+		backend: Backend{Name: "flaky", Keys: []string{"/"}, Interval: 60},
+		data:    map[string]string{"/key": "live"},
+	}
+	cache := CacheConfig{Dir: dir, Timeout: "10ms"}
+	cached, err := newBackendCache(cache, "cold-start")
+	t.Assert(err, IsNil)
+	t.Assert(cached.save(connector.backend, map[string]string{"/key": "cached"}), IsNil)
+
+	connector.down = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	res, err := NewResourceFromResourceConfig(ctx, nil, ResourceConfig{
+		Name:       "cold-start",
+		Template:   []*Renderer{{Src: tmpl, Dst: dst}},
+		Cache:      cache,
+		Connectors: []BackendConnector{connector},
+	})
+	t.Assert(err, IsNil)
+	t.Check(res.Degraded(), Equals, true)
+
+	go res.Monitor(ctx)
+	waitForContent := func(expected string) {
+		for i := 0; i < 100; i++ {
+			if data, _ := ioutil.ReadFile(dst); string(data) == expected {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Errorf("%s doesn't contain %q", dst, expected)
+	}
+	waitForContent("cached")
+
+	// the resource switches to the live data once the backend is reachable
+	atomic.StoreInt32(&connector.down, 0)
+	waitForContent("live")
+	t.Check(res.Degraded(), Equals, false)
+}
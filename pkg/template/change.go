@@ -0,0 +1,100 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/HeavyHorst/memkv"
+)
+
+// maxChangedKeysLength is the maximum length of the REMCO_CHANGED_KEYS variable.
+// The variable is left out if the changed keys don't fit, because the size of a single
+// environment variable is limited by the operating system.
+const maxChangedKeysLength = 64 * 1024
+
+// The environment variables that describe a change to the check and reload commands.
+// Lists hold one entry per line.
+const (
+	// EnvResource is the name of the resource.
+	EnvResource = "REMCO_RESOURCE"
+	// EnvBackends are the names of the backends that triggered the change.
+	EnvBackends = "REMCO_BACKENDS"
+	// EnvChangedKeys are the keys whose values were added, changed or removed.
+	EnvChangedKeys = "REMCO_CHANGED_KEYS"
+	// EnvChangedFiles are the dst files that were replaced.
+	EnvChangedFiles = "REMCO_CHANGED_FILES"
+	// EnvFileHashes holds a line "<previous hash> <new hash> <dst>" for every changed dst file.
+	// The previous hash is "-" if the dst file didn't exist.
+	EnvFileHashes = "REMCO_FILE_HASHES"
+	// EnvDst is the dst file of the template.
+	EnvDst = "REMCO_DST"
+	// EnvPreviousHash is the sha1 hash of the previous content of the dst file, empty if it didn't exist.
+	EnvPreviousHash = "REMCO_PREVIOUS_HASH"
+	// EnvNewHash is the sha1 hash of the new content of the dst file.
+	EnvNewHash = "REMCO_NEW_HASH"
+)
+
+// change describes what triggered a render of a resource.
+type change struct {
+	// backends are the names of the backends that were read.
+	backends []string
+	// keys are the changed keys, nil if they are unknown.
+	keys []string
+}
+
+// changedKeys returns the keys of old and new whose values differ, sorted.
+func changedKeys(old, new memkv.KVPairs) []string {
+	values := make(map[string]string, len(old))
+	for _, kv := range old {
+		values[kv.Key] = kv.Value
+	}
+	keys := []string{}
+	for _, kv := range new {
+		if v, ok := values[kv.Key]; !ok || v != kv.Value {
+			keys = append(keys, kv.Key)
+		}
+		delete(values, kv.Key)
+	}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// env returns the environment variables of the change for the commands of the resource name.
+// The changed files are only known after all templates were checked, so they are nil for the check commands.
+func (c change) env(name string, files []*Renderer) []string {
+	env := []string{
+		EnvResource + "=" + name,
+		EnvBackends + "=" + strings.Join(c.backends, "\n"),
+	}
+	if keys := strings.Join(c.keys, "\n"); c.keys != nil && len(keys) <= maxChangedKeysLength {
+		env = append(env, EnvChangedKeys+"="+keys)
+	}
+	if files != nil {
+		dsts := make([]string, len(files))
+		hashes := make([]string, len(files))
+		for i, s := range files {
+			dsts[i] = s.Dst
+			previous := s.previousHash
+			if previous == "" {
+				previous = "-"
+			}
+			hashes[i] = previous + " " + s.newHash + " " + s.Dst
+		}
+		env = append(env,
+			EnvChangedFiles+"="+strings.Join(dsts, "\n"),
+			EnvFileHashes+"="+strings.Join(hashes, "\n"),
+		)
+	}
+	return env
+}
@@ -0,0 +1,181 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/child"
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+)
+
+// maxCommandOutput is the maximum number of bytes of the output of a command that is kept.
+const maxCommandOutput = 64 * 1024
+
+// commandWaitDelay is the time to wait for the output of a killed command.
+const commandWaitDelay = time.Second
+
+// Command is a command that is run by the shell, or an argv list that is run without a shell.
+// In the configuration it is either a string or an array of strings, e.g.
+//
+//	check_cmd = "nginx -t -c {{.src}}"
+//	check_cmd = ["nginx", "-t", "-c", "{{.src}}"]
+type Command struct {
+	// Shell is a command line that is run with /bin/sh -c.
+	Shell string `json:"shell,omitempty"`
+	// Argv is the program and its arguments.
+	Argv []string `json:"argv,omitempty"`
+}
+
+// ShellCommand returns a Command that runs the command line cmd with the shell.
+func ShellCommand(cmd string) Command {
+	return Command{Shell: cmd}
+}
+
+// UnmarshalTOML implements the toml.Unmarshaler interface.
+func (c *Command) UnmarshalTOML(v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		*c = Command{Shell: v}
+		return nil
+	case []interface{}:
+		argv := make([]string, len(v))
+		for i, arg := range v {
+			s, ok := arg.(string)
+			if !ok {
+				return fmt.Errorf("the command arguments must be strings, got %T", arg)
+			}
+			argv[i] = s
+		}
+		if len(argv) == 0 {
+			return fmt.Errorf("the command must not be an empty array")
+		}
+		*c = Command{Argv: argv}
+		return nil
+	}
+	return fmt.Errorf("a command must be a string or an array of strings, got %T", v)
+}
+
+// IsEmpty reports whether no command is set.
+func (c Command) IsEmpty() bool {
+	return c.Shell == "" && len(c.Argv) == 0
+}
+
+// String returns the command line.
+func (c Command) String() string {
+	if len(c.Argv) > 0 {
+		return strings.Join(c.Argv, " ")
+	}
+	return c.Shell
+}
+
+// render replaces the placeholders like {{.src}} in the command line or in every argument.
+func (c Command) render(data interface{}) (Command, error) {
+	if len(c.Argv) == 0 {
+		shell, err := renderTemplate(c.Shell, data)
+		return Command{Shell: shell}, err
+	}
+	argv := make([]string, len(c.Argv))
+	for i, arg := range c.Argv {
+		var err error
+		if argv[i], err = renderTemplate(arg, data); err != nil {
+			return c, err
+		}
+	}
+	return Command{Argv: argv}, nil
+}
+
+// cmd returns the exec.Cmd that runs the command.
+func (c Command) cmd(ctx context.Context) *exec.Cmd {
+	if len(c.Argv) > 0 {
+		return exec.CommandContext(ctx, c.Argv[0], c.Argv[1:]...)
+	}
+	return exec.CommandContext(ctx, "/bin/sh", "-c", c.Shell)
+}
+
+// limitedBuffer keeps the first max bytes that are written to it.
+type limitedBuffer struct {
+	mu        sync.Mutex
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n := b.max - len(b.buf); n < len(p) {
+		b.buf = append(b.buf, p[:n]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+	return len(p), nil
+}
+
+// Bytes returns the kept output and a note if the output was truncated.
+func (b *limitedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.truncated {
+		return append(b.buf, fmt.Sprintf("\n[output truncated after %d bytes]", b.max)...)
+	}
+	return b.buf
+}
+
+// execCommand runs the command with the attributes attr and returns its combined output.
+// The environment variables env are added to the environment of remco.
+// The command runs in its own process group, which is killed if the command takes longer than timeout.
+// A timeout of 0 means no timeout. Only the first maxCommandOutput bytes of the output are kept.
+func execCommand(cmd Command, env []string, attr child.Attr, timeout time.Duration, logger hclog.Logger, rl *sync.RWMutex) ([]byte, error) {
+	logger.Debug("Running cmd", "command", cmd.String())
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	c := cmd.cmd(ctx)
+	if len(env) > 0 {
+		c.Env = append(os.Environ(), env...)
+	}
+	attr.Setpgid = true
+	if err := attr.Apply(c); err != nil {
+		return nil, err
+	}
+	c.Cancel = func() error {
+		return child.KillGroup(c.Process)
+	}
+	// don't wait for children that keep the output open after the command was killed
+	c.WaitDelay = commandWaitDelay
+	output := &limitedBuffer{max: maxCommandOutput}
+	c.Stdout = output
+	c.Stderr = output
+
+	if rl != nil {
+		rl.RLock()
+		defer rl.RUnlock()
+	}
+
+	err := c.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		metrics.IncrCounter([]string{"commands", "timeouts_total"}, 1)
+		return output.Bytes(), errors.Errorf("the command timed out after %s", timeout)
+	}
+	return output.Bytes(), err
+}
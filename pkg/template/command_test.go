@@ -0,0 +1,104 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/HeavyHorst/remco/pkg/child"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestCommandUnmarshalTOML(t *testing.T) {
+	for _, tc := range []struct {
+		config string
+		cmd    Command
+		ok     bool
+	}{
+		{`check_cmd = "nginx -t -c {{.src}}"`, ShellCommand("nginx -t -c {{.src}}"), true},
+		{`check_cmd = ["nginx", "-t", "-c", "{{.src}}"]`, Command{Argv: []string{"nginx", "-t", "-c", "{{.src}}"}}, true},
+		{`check_cmd = []`, Command{}, false},
+		{`check_cmd = ["nginx", 1]`, Command{}, false},
+		{`check_cmd = 1`, Command{}, false},
+	} {
+		var r Renderer
+		_, err := toml.Decode(tc.config, &r)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: unexpected error: %v", tc.config, err)
+			continue
+		}
+		if tc.ok && !reflect.DeepEqual(r.CheckCmd, tc.cmd) {
+			t.Errorf("%s: got %#v, want %#v", tc.config, r.CheckCmd, tc.cmd)
+		}
+	}
+}
+
+func TestExecCommand(t *testing.T) {
+	logger := hclog.New(&hclog.LoggerOptions{Output: ioutil.Discard})
+
+	// the arguments are passed without a shell
+	cmd, err := Command{Argv: []string{"echo", "{{.src}}", "$HOME;"}}.render(map[string]string{"src": "a b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := execCommand(cmd, nil, child.Attr{}, 0, logger, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(output); got != "a b $HOME;\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+
+	// the output is truncated
+	output, err = execCommand(ShellCommand("head -c 100000 /dev/zero"), nil, child.Attr{}, 0, logger, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(output) <= maxCommandOutput || !strings.HasSuffix(string(output), "[output truncated after 65536 bytes]") {
+		t.Errorf("the output of %d bytes was not truncated", len(output))
+	}
+
+	// the process group is killed on timeout, including the background sleep that keeps the output open
+	start := time.Now()
+	_, err = execCommand(ShellCommand("sleep 10 & sleep 10"), nil, child.Attr{}, 200*time.Millisecond, logger, nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+	if d := time.Since(start); d > commandWaitDelay {
+		t.Errorf("the command was not killed, it took %s", d)
+	}
+}
+
+func TestRendererValidateTimeouts(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src.tmpl")
+	if err := ioutil.WriteFile(src, []byte("{{ 1 }}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		timeout string
+		errs    int
+	}{
+		{"", 0},
+		{"5s", 0},
+		{"soon", 2},
+		{"0s", 2},
+		{"-5s", 2},
+	} {
+		r := Renderer{Src: src, Dst: "dst", CheckTimeout: tc.timeout, ReloadTimeout: tc.timeout}
+		if errs := r.Validate(); len(errs) != tc.errs {
+			t.Errorf("%q: expected %d errors, got %v", tc.timeout, tc.errs, errs)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+// ConfigError describes a problem with a single configuration option.
+type ConfigError struct {
+	// Key is the name of the configuration option, for example "mode".
+	Key string
+	Err error
+}
+
+// Error is for the error interface
+func (e ConfigError) Error() string {
+	return e.Key + ": " + e.Err.Error()
+}
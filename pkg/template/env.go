@@ -0,0 +1,104 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/HeavyHorst/memkv"
+	"github.com/HeavyHorst/pongo2"
+	"github.com/pkg/errors"
+)
+
+// isEnvTemplate reports whether the value of an exec.env entry is a pongo2 template.
+// All other values are backend keys.
+func isEnvTemplate(v string) bool {
+	return strings.Contains(v, "{{") || strings.Contains(v, "{%")
+}
+
+// validateEnv checks the variable names and templates of an exec.env table.
+func validateEnv(env map[string]string) []ConfigError {
+	var errs []ConfigError
+	for _, name := range sortedEnvNames(env) {
+		v := env[name]
+		key := "env." + name
+		switch {
+		case name == "" || strings.ContainsAny(name, "=\x00"):
+			errs = append(errs, ConfigError{Key: key, Err: fmt.Errorf("invalid environment variable name %q", name)})
+		case v == "":
+			errs = append(errs, ConfigError{Key: key, Err: fmt.Errorf("empty backend key")})
+		case isEnvTemplate(v):
+			if _, err := pongo2.FromString(v); err != nil {
+				errs = append(errs, ConfigError{Key: key, Err: err})
+			}
+		}
+	}
+	return errs
+}
+
+func sortedEnvNames(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// renderEnv returns the environment of the child process.
+// The values of env are looked up in the store or rendered with the funcMap.
+// The environment of remco is inherited unless clear is true.
+// It returns nil if env is empty and clear is false, which keeps the inherited environment.
+func renderEnv(env map[string]string, clear bool, store *memkv.Store, funcMap map[string]interface{}) ([]string, error) {
+	if len(env) == 0 && !clear {
+		return nil, nil
+	}
+	vars := []string{}
+	if !clear {
+		vars = append(vars, os.Environ()...)
+	}
+	for _, name := range sortedEnvNames(env) {
+		v := env[name]
+		var value string
+		if isEnvTemplate(v) {
+			tmpl, err := pongo2.FromString(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parsing env %s failed", name)
+			}
+			if value, err = tmpl.Execute(funcMap); err != nil {
+				return nil, errors.Wrapf(err, "rendering env %s failed", name)
+			}
+		} else {
+			kv, err := store.Get(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "env %s", name)
+			}
+			value = kv.Value
+		}
+		// the last value of duplicate names is used, so the inherited values are overwritten
+		vars = append(vars, name+"="+value)
+	}
+	return vars, nil
+}
+
+// envEqual reports whether a and b are the same environment.
+func envEqual(a, b []string) bool {
+	if (a == nil) != (b == nil) || len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
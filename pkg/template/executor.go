@@ -13,10 +13,14 @@ import (
 	"fmt"
 	"github.com/hashicorp/go-hclog"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/hashicorp/consul-template/child"
+	"github.com/HeavyHorst/remco/pkg/child"
 	"github.com/hashicorp/consul-template/signals"
 	"github.com/mattn/go-shellwords"
 	"github.com/pkg/errors"
@@ -44,6 +48,239 @@ type ExecConfig struct {
 	// A random splay to wait before killing the command.
 	// May be useful in large clusters to prevent all child processes to reload at the same time when configuration changes occur.
 	Splay int `json:"splay"`
+
+	// Restart is the restart policy of the child process: "always", "on-failure" or "never".
+	// If no policy is set, the whole resource is restarted if the child exits.
+	Restart RestartPolicy `json:"restart"`
+
+	// MaxRestarts is the maximum number of consecutive restarts of the child process.
+	// The whole resource is restarted if the child exits more often. 0 means unlimited.
+	MaxRestarts int `toml:"max_restarts" json:"max_restarts"`
+
+	// RestartBackoff is the time to wait before the first restart of the child process, e.g. "1s".
+	// The time doubles with every consecutive restart.
+	RestartBackoff string `toml:"restart_backoff" json:"restart_backoff"`
+
+	// RestartBackoffMax is the maximum time to wait before a restart, e.g. "1m".
+	// The restart counter is reset if the child process runs longer than RestartBackoffMax.
+	RestartBackoffMax string `toml:"restart_backoff_max" json:"restart_backoff_max"`
+
+	// ReloadMode decides how the child process is reloaded: "signal", "restart" or "start-then-stop".
+	// By default the reload_signal is sent if configured, the child is restarted otherwise.
+	ReloadMode ReloadMode `toml:"reload_mode" json:"reload_mode"`
+
+	// ReadyTimeout is the maximum time to wait for the readiness check of a new child
+	// in the start-then-stop reload mode. Defaults to 30s.
+	ReadyTimeout string `toml:"ready_timeout" json:"ready_timeout"`
+
+	// Health configures the checks of the child process.
+	Health HealthConfig `json:"health"`
+
+	// Env maps environment variable names of the child process to backend keys or pongo2 templates,
+	// e.g. DB_HOST = "/app/db/host" or DB_URL = "postgres://{{ getv('/app/db/host') }}/app".
+	// The child process is restarted if a value changes.
+	Env map[string]string `json:"env"`
+
+	// ClearEnv starts the child process with the variables from Env only.
+	// The environment of remco is inherited otherwise.
+	ClearEnv bool `toml:"clear_env" json:"clear_env"`
+
+	// Output configures where the stdout and stderr of the child process are written to.
+	Output OutputConfig `json:"output"`
+
+	// User is the user name or uid the child process runs as.
+	User string `json:"user"`
+
+	// Group is the group name or gid the child process runs as. Defaults to the primary group of User.
+	Group string `json:"group"`
+
+	// WorkingDir is the working directory of the child process.
+	WorkingDir string `toml:"working_dir" json:"working_dir"`
+
+	// Umask is the octal umask of the child process, e.g. "027".
+	Umask string `json:"umask"`
+
+	// Rlimits are the resource limits of the child process: "nofile", "nproc" and "core".
+	Rlimits map[string]uint64 `json:"rlimits"`
+}
+
+// attr returns the process attributes of the child process.
+func (c ExecConfig) attr() (child.Attr, error) {
+	attr := child.Attr{Dir: c.WorkingDir, Rlimits: c.Rlimits}
+	if c.User != "" {
+		cred, err := child.LookupCredential(c.User, c.Group)
+		if err != nil {
+			return attr, err
+		}
+		attr.Credential = cred
+	} else if c.Group != "" {
+		return attr, fmt.Errorf("a group requires a user")
+	}
+	if c.Umask != "" {
+		umask, err := parseUmask(c.Umask)
+		if err != nil {
+			return attr, err
+		}
+		attr.Umask = &umask
+	}
+	if err := child.ValidateRlimits(c.Rlimits); err != nil {
+		return attr, err
+	}
+	return attr, nil
+}
+
+// parseUmask parses an octal umask like "027".
+func parseUmask(s string) (int, error) {
+	umask, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || umask > 0777 {
+		return 0, fmt.Errorf("invalid umask %q", s)
+	}
+	return int(umask), nil
+}
+
+// HealthConfig holds the checks of the child process.
+type HealthConfig struct {
+	// Readiness checks if the child process is ready to serve.
+	// In the start-then-stop reload mode the old child is stopped once the new child is ready.
+	Readiness ProbeConfig `json:"readiness"`
+
+	// Liveness checks if the child process is still working.
+	// The child is restarted if the check fails failure_threshold times in a row.
+	Liveness ProbeConfig `json:"liveness"`
+}
+
+// ReloadMode decides how a child process is reloaded after the templates changed.
+type ReloadMode string
+
+const (
+	// ReloadSignal sends the reload_signal to the child process.
+	ReloadSignal ReloadMode = "signal"
+	// ReloadRestart stops the child process and starts it again.
+	ReloadRestart ReloadMode = "restart"
+	// ReloadStartThenStop starts a new child process and stops the old one once the new child is ready.
+	ReloadStartThenStop ReloadMode = "start-then-stop"
+)
+
+// Validate returns an error if m is not a known reload mode.
+func (m ReloadMode) Validate() error {
+	switch m {
+	case "", ReloadSignal, ReloadRestart, ReloadStartThenStop:
+		return nil
+	}
+	return fmt.Errorf("unknown reload mode %q - valid modes are %q, %q and %q", string(m), ReloadSignal, ReloadRestart, ReloadStartThenStop)
+}
+
+const defaultReadyTimeout = 30 * time.Second
+
+// readyTimeout returns the parsed ready timeout.
+func (c ExecConfig) readyTimeout() (time.Duration, error) {
+	if c.ReadyTimeout == "" {
+		return defaultReadyTimeout, nil
+	}
+	d, err := time.ParseDuration(c.ReadyTimeout)
+	if err != nil {
+		return 0, errors.Wrap(err, "parsing ready_timeout failed")
+	}
+	return d, nil
+}
+
+// RestartPolicy decides if a child process is restarted after it exited.
+type RestartPolicy string
+
+const (
+	// RestartAlways restarts the child process after every exit.
+	RestartAlways RestartPolicy = "always"
+	// RestartOnFailure restarts the child process if it exits with a non-zero exit code.
+	RestartOnFailure RestartPolicy = "on-failure"
+	// RestartNever never restarts the child process. The templates are still rendered.
+	RestartNever RestartPolicy = "never"
+)
+
+// Validate returns an error if p is not a known policy.
+func (p RestartPolicy) Validate() error {
+	switch p {
+	case "", RestartAlways, RestartOnFailure, RestartNever:
+		return nil
+	}
+	return fmt.Errorf("unknown restart policy %q - valid policies are %q, %q and %q", string(p), RestartAlways, RestartOnFailure, RestartNever)
+}
+
+const (
+	defaultRestartBackoff    = time.Second
+	defaultRestartBackoffMax = time.Minute
+)
+
+// restartBackoff returns the parsed restart backoff durations.
+func (c ExecConfig) restartBackoff() (time.Duration, time.Duration, error) {
+	minBackoff, maxBackoff := defaultRestartBackoff, defaultRestartBackoffMax
+	var err error
+	if c.RestartBackoff != "" {
+		if minBackoff, err = time.ParseDuration(c.RestartBackoff); err != nil {
+			return 0, 0, errors.Wrap(err, "parsing restart_backoff failed")
+		}
+	}
+	if c.RestartBackoffMax != "" {
+		if maxBackoff, err = time.ParseDuration(c.RestartBackoffMax); err != nil {
+			return 0, 0, errors.Wrap(err, "parsing restart_backoff_max failed")
+		}
+	}
+	if maxBackoff < minBackoff {
+		return 0, 0, fmt.Errorf("restart_backoff_max (%s) is smaller than restart_backoff (%s)", maxBackoff, minBackoff)
+	}
+	return minBackoff, maxBackoff, nil
+}
+
+// Validate checks the exec configuration.
+// It reports signals that can't be parsed.
+func (c ExecConfig) Validate() []ConfigError {
+	var errs []ConfigError
+	if c.ReloadSignal != "" {
+		if _, err := signals.Parse(c.ReloadSignal); err != nil {
+			errs = append(errs, ConfigError{Key: "reload_signal", Err: err})
+		}
+	}
+	if c.KillSignal != "" {
+		if _, err := signals.Parse(c.KillSignal); err != nil {
+			errs = append(errs, ConfigError{Key: "kill_signal", Err: err})
+		}
+	}
+	if err := c.Restart.Validate(); err != nil {
+		errs = append(errs, ConfigError{Key: "restart", Err: err})
+	}
+	if c.MaxRestarts < 0 {
+		errs = append(errs, ConfigError{Key: "max_restarts", Err: fmt.Errorf("must not be negative")})
+	}
+	if _, _, err := c.restartBackoff(); err != nil {
+		errs = append(errs, ConfigError{Key: "restart_backoff", Err: err})
+	}
+	if err := c.ReloadMode.Validate(); err != nil {
+		errs = append(errs, ConfigError{Key: "reload_mode", Err: err})
+	} else if c.ReloadMode == ReloadSignal && c.ReloadSignal == "" {
+		errs = append(errs, ConfigError{Key: "reload_mode", Err: fmt.Errorf("the reload mode %q requires a reload_signal", ReloadSignal)})
+	}
+	if _, err := c.readyTimeout(); err != nil {
+		errs = append(errs, ConfigError{Key: "ready_timeout", Err: err})
+	}
+	errs = append(errs, c.Health.Readiness.Validate("health.readiness")...)
+	errs = append(errs, c.Health.Liveness.Validate("health.liveness")...)
+	errs = append(errs, validateEnv(c.Env)...)
+	errs = append(errs, c.Output.Validate()...)
+	if c.User != "" {
+		if _, err := child.LookupCredential(c.User, c.Group); err != nil {
+			errs = append(errs, ConfigError{Key: "user", Err: err})
+		}
+	} else if c.Group != "" {
+		errs = append(errs, ConfigError{Key: "group", Err: fmt.Errorf("a group requires a user")})
+	}
+	if c.Umask != "" {
+		if _, err := parseUmask(c.Umask); err != nil {
+			errs = append(errs, ConfigError{Key: "umask", Err: err})
+		}
+	}
+	if err := child.ValidateRlimits(c.Rlimits); err != nil {
+		errs = append(errs, ConfigError{Key: "rlimits", Err: err})
+	}
+	return errs
 }
 
 type childSignal struct {
@@ -51,6 +288,11 @@ type childSignal struct {
 	err    chan<- error
 }
 
+type childEnv struct {
+	env []string
+	err chan<- error
+}
+
 type exitC struct {
 	exitChan <-chan int
 	valid    bool
@@ -68,10 +310,48 @@ type Executor struct {
 	splay        time.Duration
 	logger       hclog.Logger
 
-	stopChan   chan chan<- error
-	reloadChan chan chan<- error
-	signalChan chan childSignal
-	exitChan   chan chan exitC
+	restart     RestartPolicy
+	maxRestarts int
+	backoffMin  time.Duration
+	backoffMax  time.Duration
+
+	reloadMode   ReloadMode
+	readiness    ProbeConfig
+	liveness     ProbeConfig
+	readyTimeout time.Duration
+
+	// env is the environment of the child process, nil inherits the environment of remco.
+	env []string
+
+	// attr holds the credentials, the working directory, the umask and the resource limits of the child process.
+	attr child.Attr
+
+	// name is the name of the resource, it prefixes the output of the child process.
+	name   string
+	output OutputConfig
+	// out receives the output of the child process, it is set by SpawnChild.
+	out *childOutput
+
+	// restarts is the total number of restarts of the child process, it is accessed atomically.
+	restarts int32
+	// ready is 1 if the child process is running and the readiness probe succeeds, it is accessed atomically.
+	ready int32
+	// unhealthy is 1 if the child process was stopped because the liveness probe failed, it is accessed atomically.
+	unhealthy int32
+	// current holds the *child.Child that is managed by the control goroutine of SpawnChild.
+	current atomic.Value
+	// failures records every unexpected exit of the child process and every failed liveness probe.
+	failures *failureLog
+
+	stopChan    chan chan<- error
+	reloadChan  chan chan<- error
+	restartChan chan chan<- error
+	signalChan  chan childSignal
+	envChan     chan childEnv
+	exitChan    chan chan exitC
+
+	// livenessChan stops the child process after the liveness probe failed.
+	livenessChan chan chan<- error
 }
 
 // NewExecutor creates a new Executor.
@@ -114,11 +394,52 @@ func NewExecutor(execCommand, reloadSignal, killSignal string, killTimeout, spla
 		logger:       logger,
 		stopChan:     make(chan chan<- error),
 		reloadChan:   make(chan chan<- error),
+		restartChan:  make(chan chan<- error),
 		signalChan:   make(chan childSignal),
+		envChan:      make(chan childEnv),
 		exitChan:     make(chan chan exitC),
+		livenessChan: make(chan chan<- error),
+		failures:     &failureLog{},
 	}
 }
 
+// newExecutorFromConfig creates a new Executor from the ExecConfig for the resource name.
+func newExecutorFromConfig(name, execCommand string, c ExecConfig, logger hclog.Logger) (Executor, error) {
+	if err := c.Restart.Validate(); err != nil {
+		return Executor{}, err
+	}
+	backoffMin, backoffMax, err := c.restartBackoff()
+	if err != nil {
+		return Executor{}, err
+	}
+	if err := c.ReloadMode.Validate(); err != nil {
+		return Executor{}, err
+	}
+	readyTimeout, err := c.readyTimeout()
+	if err != nil {
+		return Executor{}, err
+	}
+	if err := c.Output.Mode.Validate(); err != nil {
+		return Executor{}, err
+	}
+	attr, err := c.attr()
+	if err != nil {
+		return Executor{}, err
+	}
+	e := NewExecutor(execCommand, c.ReloadSignal, c.KillSignal, c.KillTimeout, c.Splay, logger)
+	e.restart = c.Restart
+	e.maxRestarts = c.MaxRestarts
+	e.backoffMin, e.backoffMax = backoffMin, backoffMax
+	e.reloadMode = c.ReloadMode
+	e.readiness = c.Health.Readiness
+	e.liveness = c.Health.Liveness
+	e.name = name
+	e.attr = attr
+	e.output = c.Output
+	e.readyTimeout = readyTimeout
+	return e, nil
+}
+
 // SpawnChild parses e.execCommand and starts the child process accordingly.
 // Backtick parsing is supported:
 //   ./foo `echo $SHELL`
@@ -126,6 +447,7 @@ func NewExecutor(execCommand, reloadSignal, killSignal string, killTimeout, spla
 // only call this once !
 func (e *Executor) SpawnChild() error {
 	var c *child.Child
+	var input *child.NewInput
 	if e.execCommand != "" {
 		p := shellwords.NewParser()
 		p.ParseBacktick = true
@@ -138,18 +460,26 @@ func (e *Executor) SpawnChild() error {
 			return fmt.Errorf("exec_command %q parsed to no tokens", e.execCommand)
 		}
 
-		c, err = child.New(&child.NewInput{
+		e.out = newChildOutput(e.output, e.name, e.logger)
+		input = &child.NewInput{
 			Stdin:        os.Stdin,
-			Stdout:       os.Stdout,
-			Stderr:       os.Stderr,
+			Stdout:       e.out.Stdout,
+			Stderr:       e.out.Stderr,
 			Command:      args[0],
 			Args:         args[1:],
+			Env:          e.env,
+			Attr:         e.attr,
 			ReloadSignal: e.reloadSignal,
 			KillSignal:   e.killSignal,
 			KillTimeout:  e.killTimeout,
 			Splay:        e.splay,
 			Logger:       e.logger.StandardLogger(&hclog.StandardLoggerOptions{InferLevels: true}),
-		})
+		}
+		if e.reloadMode == ReloadRestart {
+			// the child restarts on reload if no reload signal is set
+			input.ReloadSignal = nil
+		}
+		c, err = child.New(input)
 
 		if err != nil {
 			return fmt.Errorf("error creating child: %s", err)
@@ -158,23 +488,93 @@ func (e *Executor) SpawnChild() error {
 		if err := c.Start(); err != nil {
 			return fmt.Errorf("error starting child: %s", err)
 		}
+		e.setRunning(true)
 	}
 
+	e.current.Store(c)
 	go func() {
+		// h is the pending handover to a new child in the start-then-stop reload mode.
+		var h *handover
+		// stopping waits for the old children that are stopped after a handover.
+		var stopping sync.WaitGroup
 		for {
+			e.current.Store(c)
+			var readyChan <-chan error
+			if h != nil {
+				readyChan = h.ready
+			}
 			select {
 			case errchan := <-e.stopChan:
+				if h != nil {
+					h.abort(fmt.Errorf("the child is stopped"))
+					h = nil
+				}
 				if c != nil {
 					c.Stop()
+					stopping.Wait()
+					if err := e.out.Close(); err != nil {
+						e.logger.Error("failed to close the output of the child", "error", err)
+					}
 				}
 				errchan <- nil
 				return
-			case errchan := <-e.reloadChan:
+			case err := <-readyChan:
+				c = e.finishHandover(c, h, err, &stopping)
+				h = nil
+			case errchan := <-e.restartChan:
 				var err error
 				if c != nil {
+					// a stopped child can't be started again, the exited child is replaced by a new child
+					select {
+					case <-c.DoneCh():
+					default:
+						c.Stop()
+					}
+					var nc *child.Child
+					if nc, err = child.New(input); err == nil {
+						c = nc
+						err = c.Start()
+					}
+				}
+				errchan <- err
+			case errchan := <-e.livenessChan:
+				if c != nil {
+					select {
+					case <-c.DoneCh():
+						// the child exited already
+					default:
+						atomic.StoreInt32(&e.unhealthy, 1)
+						c.Stop()
+					}
+				}
+				errchan <- nil
+			case errchan := <-e.reloadChan:
+				var err error
+				switch {
+				case c == nil:
+				case e.reloadMode == ReloadStartThenStop:
+					if h, err = e.startHandover(h, input, input.Env, errchan); err == nil {
+						// the reload is answered once the new child is ready
+						continue
+					}
+				default:
 					err = c.Reload()
 				}
 				errchan <- err
+			case u := <-e.envChan:
+				var err error
+				switch {
+				case c == nil:
+				case e.reloadMode == ReloadStartThenStop:
+					previous := input.Env
+					input.Env = u.env
+					if h, err = e.startHandover(h, input, previous, u.err); err == nil {
+						continue
+					}
+				default:
+					c, err = e.replaceChild(c, input, u.env)
+				}
+				u.err <- err
 			case s := <-e.signalChan:
 				var err error
 				if c != nil {
@@ -201,6 +601,122 @@ func (e *Executor) SpawnChild() error {
 	return nil
 }
 
+// A handover is a new child process in the start-then-stop reload mode
+// that replaces the running child once it is ready.
+type handover struct {
+	child  *child.Child
+	cancel context.CancelFunc
+	// ready receives the result of the readiness check of the new child.
+	ready chan error
+	// input and previousEnv restore the environment if the new child doesn't get ready.
+	input       *child.NewInput
+	previousEnv []string
+	// err receives the result of the reload.
+	err chan<- error
+}
+
+// abort stops the new child and answers the reload with err.
+func (h *handover) abort(err error) {
+	h.cancel()
+	h.child.StopImmediately()
+	h.input.Env = h.previousEnv
+	h.err <- err
+}
+
+// startHandover starts a new child process and waits in the background until it is ready.
+// The readiness probe receives the pid of the new child, the wait fails if the new child exits.
+// Only one handover runs at a time, pending is the running handover or nil.
+// The environment is restored to previousEnv if the new child can't be started.
+func (e *Executor) startHandover(pending *handover, input *child.NewInput, previousEnv []string, errchan chan<- error) (*handover, error) {
+	if pending != nil {
+		input.Env = previousEnv
+		return pending, fmt.Errorf("a new child is already starting")
+	}
+	c, err := child.New(input)
+	if err != nil {
+		input.Env = previousEnv
+		return nil, fmt.Errorf("error creating child: %s", err)
+	}
+	if err := c.Start(); err != nil {
+		input.Env = previousEnv
+		return nil, fmt.Errorf("error starting child: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &handover{
+		child:       c,
+		cancel:      cancel,
+		ready:       make(chan error, 1),
+		input:       input,
+		previousEnv: previousEnv,
+		err:         errchan,
+	}
+	done := c.DoneCh()
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	go func() {
+		err := e.readiness.waitUntilReady(ctx, e.readyTimeout, c.Pid())
+		select {
+		case <-done:
+			err = fmt.Errorf("the new child exited")
+		default:
+		}
+		h.ready <- err
+	}()
+	return h, nil
+}
+
+// finishHandover completes the handover h with the result of the readiness check.
+// If the new child is ready, the old child is stopped in the background and the new child is returned.
+// The new child is stopped and the old child keeps running otherwise.
+func (e *Executor) finishHandover(old *child.Child, h *handover, err error, stopping *sync.WaitGroup) *child.Child {
+	if err != nil {
+		h.abort(errors.Wrap(err, "the new child didn't get ready, keeping the old child"))
+		return old
+	}
+	h.cancel()
+	e.logger.Info("new child is ready, stopping the old child")
+	stopping.Add(1)
+	go func() {
+		defer stopping.Done()
+		old.Stop()
+	}()
+	h.err <- nil
+	return h.child
+}
+
+// replaceChild stops the child process and starts a new child with the environment env.
+// It returns the running child.
+func (e *Executor) replaceChild(old *child.Child, input *child.NewInput, env []string) (*child.Child, error) {
+	previous := input.Env
+	input.Env = env
+	c, err := child.New(input)
+	if err != nil {
+		input.Env = previous
+		return old, fmt.Errorf("error creating child: %s", err)
+	}
+	old.Stop()
+	if err := c.Start(); err != nil {
+		return c, fmt.Errorf("error starting child: %s", err)
+	}
+	return c, nil
+}
+
+// SetEnv restarts the child process with the new environment env.
+func (e *Executor) SetEnv(env []string) error {
+	errchan := make(chan error)
+	e.envChan <- childEnv{env: env, err: errchan}
+	if err := <-errchan; err != nil {
+		return errors.Wrap(err, "restart with the new environment failed")
+	}
+	return nil
+}
+
 // SignalChild forwards the os.Signal to the child process.
 func (e *Executor) SignalChild(s os.Signal) error {
 	err := make(chan error)
@@ -237,6 +753,145 @@ func (e *Executor) Reload() error {
 	return nil
 }
 
+// restartChild starts a new child process after the child exited.
+func (e *Executor) restartChild() error {
+	errchan := make(chan error)
+	e.restartChan <- errchan
+	if err := <-errchan; err != nil {
+		return errors.Wrap(err, "restart failed")
+	}
+	atomic.AddInt32(&e.restarts, 1)
+	return nil
+}
+
+// stopUnhealthyChild stops the child process gracefully after the liveness probe failed.
+// Wait handles the exit according to the restart policy.
+func (e *Executor) stopUnhealthyChild() {
+	errchan := make(chan error)
+	e.livenessChan <- errchan
+	<-errchan
+}
+
+// waitForRestart waits until the child process was restarted more than restarts times.
+// It returns false if the context is canceled first.
+func (e *Executor) waitForRestart(ctx context.Context, restarts int) bool {
+	for e.Restarts() <= restarts {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return true
+}
+
+// Pid returns the process id of the child process, 0 if no child process runs.
+func (e *Executor) Pid() int {
+	c, _ := e.current.Load().(*child.Child)
+	if c == nil {
+		return 0
+	}
+	return c.Pid()
+}
+
+// Ready reports whether the child process is running and its readiness probe succeeds.
+// It always returns true if no command is configured.
+func (e *Executor) Ready() bool {
+	if e.execCommand == "" {
+		return true
+	}
+	return atomic.LoadInt32(&e.ready) == 1
+}
+
+func (e *Executor) setReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&e.ready, v)
+}
+
+// setRunning updates the readiness after the child was started or exited.
+// If a readiness probe is configured, a new child is only ready once the probe succeeds.
+func (e *Executor) setRunning(running bool) {
+	if !running || !e.readiness.enabled() {
+		e.setReady(running)
+	}
+}
+
+// WatchHealth runs the readiness and liveness probes until the context is canceled.
+// The child process is stopped if the liveness probe fails, the restart policy decides if it is started again.
+func (e *Executor) WatchHealth(ctx context.Context) {
+	if e.execCommand == "" {
+		return
+	}
+	var wg sync.WaitGroup
+	if e.readiness.enabled() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.readiness.run(ctx, e.Pid, e.Ready, func(healthy bool, err error) {
+				if healthy {
+					e.logger.Info("child is ready")
+				} else {
+					e.logger.Warn("child is not ready", "error", err)
+				}
+				e.setReady(healthy)
+			})
+		}()
+	}
+	if e.liveness.enabled() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			alive := func() bool { return true }
+			e.liveness.run(ctx, e.Pid, alive, func(_ bool, err error) {
+				e.logger.Error("liveness check failed, stopping child", "error", err)
+				restarts := e.Restarts()
+				e.stopUnhealthyChild()
+				if !e.waitForRestart(ctx, restarts) {
+					return
+				}
+				// give the new child the same time to start as the first one
+				if delay, _ := e.liveness.initialDelay(); delay > 0 {
+					select {
+					case <-ctx.Done():
+					case <-time.After(delay):
+					}
+				}
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+// Restarts returns the number of restarts of the child process according to the restart policy.
+func (e *Executor) Restarts() int {
+	return int(atomic.LoadInt32(&e.restarts))
+}
+
+// shouldRestart reports whether the child process is restarted after it exited with the exit code.
+func (e *Executor) shouldRestart(code int) bool {
+	switch e.restart {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return code != 0
+	}
+	return false
+}
+
+// logCrash logs the exit code and the last lines of the output of the crashed child process.
+func (e *Executor) logCrash(code int) {
+	e.out.Flush()
+	tail := e.out.Tail()
+	if len(tail) == 0 {
+		e.logger.Error("child crashed", "exit_code", code)
+		return
+	}
+	e.logger.Error(fmt.Sprintf("child crashed, last %d lines of output:\n%s", len(tail), strings.Join(tail, "\n")), "exit_code", code)
+}
+
 func (e *Executor) getExitChan() (<-chan int, bool) {
 	ecc := make(chan exitC)
 	e.exitChan <- ecc
@@ -247,6 +902,10 @@ func (e *Executor) getExitChan() (<-chan int, bool) {
 // Wait waits for the child to stop.
 // Returns true if the command stops unexpectedly and false if the context is canceled.
 //
+// If a restart policy is configured, the child is restarted with an exponential backoff instead.
+// Wait returns true if the child exits more than maxRestarts times in a row.
+// If the policy doesn't restart the child, Wait blocks until the context is canceled.
+//
 // Wait ignores reloads.
 func (e *Executor) Wait(ctx context.Context) bool {
 	exitChan, valid := e.getExitChan()
@@ -254,11 +913,13 @@ func (e *Executor) Wait(ctx context.Context) bool {
 		return false
 	}
 
+	var restarts int
+	started := time.Now()
 	for {
 		select {
 		case <-ctx.Done():
 			return false
-		case <-exitChan:
+		case code := <-exitChan:
 			// wait a little bit to give the process time to start
 			// in case of a reload
 			time.Sleep(1 * time.Second)
@@ -269,8 +930,47 @@ func (e *Executor) Wait(ctx context.Context) bool {
 				exitChan = nexitChan
 				continue
 			}
-			// the process exited - stop
-			return true
+			// the process exited
+			e.failures.add(time.Now())
+			e.setRunning(false)
+			if atomic.CompareAndSwapInt32(&e.unhealthy, 1, 0) {
+				// the child was stopped because the liveness probe failed
+				code = child.ExitCodeError
+			} else if code != 0 {
+				e.logCrash(code)
+			}
+			if e.restart == "" {
+				return true
+			}
+			if !e.shouldRestart(code) {
+				e.logger.Info("child exited, not restarting", "exit_code", code, "restart", string(e.restart))
+				<-ctx.Done()
+				return false
+			}
+
+			// the child was running long enough to be considered stable
+			if time.Since(started) > e.backoffMax {
+				restarts = 0
+			}
+			if e.maxRestarts > 0 && restarts >= e.maxRestarts {
+				e.logger.Error("child exited too often, giving up", "exit_code", code, "restarts", restarts)
+				return true
+			}
+			delay := backoff(e.backoffMin, e.backoffMax, restarts)
+			e.logger.Warn(fmt.Sprintf("child exited, restarting in %s", delay), "exit_code", code)
+			select {
+			case <-ctx.Done():
+				return false
+			case <-time.After(delay):
+			}
+			if err := e.restartChild(); err != nil {
+				e.logger.Error("failed to restart child", "error", err)
+				return true
+			}
+			e.setRunning(true)
+			restarts++
+			started = time.Now()
+			exitChan, _ = e.getExitChan()
 		}
 	}
 }
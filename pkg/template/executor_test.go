@@ -11,9 +11,14 @@ package template
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"github.com/hashicorp/go-hclog"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -273,3 +278,346 @@ func TestSpawnChildEmptyCommand(t *testing.T) {
 		}
 	}
 }
+
+func TestRestartPolicy(t *testing.T) {
+	logger := hclog.New(&hclog.LoggerOptions{Output: ioutil.Discard})
+	exec, err := newExecutorFromConfig("test", "bash -c 'exit 1'", ExecConfig{
+		Restart:        RestartOnFailure,
+		MaxRestarts:    2,
+		RestartBackoff: "10ms",
+	}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.SpawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	defer exec.StopChild()
+
+	// the child is restarted twice, the third exit fails the resource
+	if !exec.Wait(context.Background()) {
+		t.Error("exec.Wait should return true after max_restarts")
+	}
+	if exec.Restarts() != 2 {
+		t.Errorf("the child should be restarted 2 times, was %d", exec.Restarts())
+	}
+}
+
+func TestRestartPolicyNoRestart(t *testing.T) {
+	logger := hclog.New(&hclog.LoggerOptions{Output: ioutil.Discard})
+	exec, err := newExecutorFromConfig("test", "bash -c 'exit 0'", ExecConfig{Restart: RestartOnFailure}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.SpawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	defer exec.StopChild()
+
+	// a successful exit is not restarted and doesn't fail the resource
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if exec.Wait(ctx) {
+		t.Error("exec.Wait should return false if the context is canceled")
+	}
+	if exec.Restarts() != 0 {
+		t.Errorf("the child shouldn't be restarted, was restarted %d times", exec.Restarts())
+	}
+}
+
+func TestExecConfigValidate(t *testing.T) {
+	errs := ExecConfig{Restart: "sometimes", MaxRestarts: -1, RestartBackoff: "1m", RestartBackoffMax: "1s"}.Validate()
+	if len(errs) != 3 {
+		t.Errorf("expected 3 errors, got %v", errs)
+	}
+
+	errs = ExecConfig{Group: "root", Umask: "999", Rlimits: map[string]uint64{"stack": 1}}.Validate()
+	if len(errs) != 3 {
+		t.Errorf("expected 3 errors, got %v", errs)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if d := backoff(time.Second, 5*time.Second, attempt); d != expected {
+			t.Errorf("attempt %d: expected %s, got %s", attempt, expected, d)
+		}
+	}
+}
+
+func TestReloadStartThenStop(t *testing.T) {
+	logger := hclog.New(&hclog.LoggerOptions{Output: ioutil.Discard})
+	for _, ready := range []bool{true, false} {
+		probe := ProbeConfig{Command: "exit 0", Interval: "10ms"}
+		if !ready {
+			probe.Command = "exit 1"
+		}
+		exec, err := newExecutorFromConfig("test", "bash -c 'sleep 5'", ExecConfig{
+			ReloadMode:   ReloadStartThenStop,
+			ReadyTimeout: "200ms",
+			Health:       HealthConfig{Readiness: probe},
+		}, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := exec.SpawnChild(); err != nil {
+			t.Fatal(err)
+		}
+		exitChan, _ := exec.getExitChan()
+
+		err = exec.Reload()
+		nexitChan, _ := exec.getExitChan()
+		if ready {
+			if err != nil {
+				t.Error(err)
+			}
+			if nexitChan == exitChan {
+				t.Error("the new child should replace the old child")
+			}
+			// the old child was stopped
+			if _, ok := <-exitChan; ok {
+				t.Error("the old child shouldn't report an exit code")
+			}
+		} else {
+			if err == nil {
+				t.Error("the reload should fail if the new child doesn't get ready")
+			}
+			if nexitChan != exitChan {
+				t.Error("the old child should keep running")
+			}
+		}
+		exec.StopChild()
+	}
+}
+
+func TestReloadStartThenStopNewChildExits(t *testing.T) {
+	logger := hclog.New(&hclog.LoggerOptions{Output: ioutil.Discard})
+	marker := filepath.Join(t.TempDir(), "marker")
+	// the probe succeeds for the old child too, like a port that is shared with SO_REUSEPORT
+	// only the first child runs, the following children exit
+	exec, err := newExecutorFromConfig("test", fmt.Sprintf("bash -c 'test -f %[1]s && exit 1; touch %[1]s; sleep 5'", marker), ExecConfig{
+		ReloadMode:   ReloadStartThenStop,
+		ReadyTimeout: "5s",
+		Health:       HealthConfig{Readiness: ProbeConfig{Command: "sleep 0.5", Interval: "10ms"}},
+	}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.SpawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	defer exec.StopChild()
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(marker); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	pid := exec.Pid()
+	if err := exec.Reload(); err == nil {
+		t.Error("the reload should fail if the new child exits")
+	}
+	if exec.Pid() != pid {
+		t.Error("the old child should keep running")
+	}
+}
+
+func TestStopChildDuringHandover(t *testing.T) {
+	logger := hclog.New(&hclog.LoggerOptions{Output: ioutil.Discard})
+	exec, err := newExecutorFromConfig("test", "bash -c 'sleep 5'", ExecConfig{
+		ReloadMode:   ReloadStartThenStop,
+		ReadyTimeout: "10s",
+		Health:       HealthConfig{Readiness: ProbeConfig{Command: "exit 1", Interval: "10ms"}},
+	}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.SpawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	reloadErr := make(chan error)
+	go func() {
+		reloadErr <- exec.Reload()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	if err := exec.SignalChild(syscall.Signal(0)); err != nil {
+		t.Error(err)
+	}
+	exec.StopChild()
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("stopping the child shouldn't wait for the ready_timeout, took %s", d)
+	}
+	if err := <-reloadErr; err == nil {
+		t.Error("the reload should fail if the child is stopped")
+	}
+}
+
+func TestReloadModeValidate(t *testing.T) {
+	if errs := (ExecConfig{ReloadMode: ReloadSignal}).Validate(); len(errs) != 1 {
+		t.Errorf("the signal reload mode requires a reload_signal, got %v", errs)
+	}
+	if errs := (ExecConfig{ReloadMode: "reexec"}).Validate(); len(errs) != 1 {
+		t.Errorf("expected an unknown reload mode error, got %v", errs)
+	}
+	probe := ProbeConfig{TCP: "localhost:80", HTTP: "http://localhost/"}
+	if errs := (ExecConfig{Health: HealthConfig{Readiness: probe}}).Validate(); len(errs) != 1 {
+		t.Errorf("expected a probe error, got %v", errs)
+	}
+}
+
+func TestHealthProbes(t *testing.T) {
+	logger := hclog.New(&hclog.LoggerOptions{Output: ioutil.Discard})
+	readyFile := filepath.Join(t.TempDir(), "ready")
+	exec, err := newExecutorFromConfig("test", "bash -c 'sleep 5'", ExecConfig{
+		Restart:        RestartAlways,
+		RestartBackoff: "10ms",
+		Health: HealthConfig{
+			Readiness: ProbeConfig{Command: "test -f " + readyFile, Interval: "10ms"},
+			Liveness:  ProbeConfig{Command: "exit 1", Interval: "10ms", FailureThreshold: 2},
+		},
+	}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.SpawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	defer exec.StopChild()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go exec.WatchHealth(ctx)
+	go exec.Wait(ctx)
+
+	waitFor := func(cond func() bool, msg string) {
+		for i := 0; i < 300; i++ {
+			if cond() {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Error(msg)
+	}
+
+	if exec.Ready() {
+		t.Error("the child shouldn't be ready before the readiness probe succeeds")
+	}
+	if err := ioutil.WriteFile(readyFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(exec.Ready, "the child should be ready")
+	waitFor(func() bool { return exec.Restarts() > 0 }, "the child should be restarted if the liveness probe fails")
+}
+
+func TestLivenessRestartPolicyNever(t *testing.T) {
+	logger := hclog.New(&hclog.LoggerOptions{Output: ioutil.Discard})
+	exec, err := newExecutorFromConfig("test", "bash -c 'sleep 5'", ExecConfig{
+		Restart: RestartNever,
+		Health: HealthConfig{
+			Liveness: ProbeConfig{Command: "exit 1", Interval: "10ms", FailureThreshold: 2},
+		},
+	}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.SpawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	defer exec.StopChild()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go exec.WatchHealth(ctx)
+	go exec.Wait(ctx)
+
+	for i := 0; i < 100 && exec.Pid() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exec.Pid() != 0 {
+		t.Error("the child should be stopped if the liveness probe fails")
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if exec.Pid() != 0 || exec.Restarts() != 0 {
+		t.Error("the child shouldn't be restarted with the restart policy never")
+	}
+}
+
+func TestProbeValidate(t *testing.T) {
+	errs := ExecConfig{Health: HealthConfig{
+		Liveness: ProbeConfig{TCP: "localhost:80", FailureThreshold: -1, InitialDelay: "soon"},
+	}}.Validate()
+	if len(errs) != 2 {
+		t.Errorf("expected 2 errors, got %v", errs)
+	}
+	// a zero interval would run the checks back to back
+	for _, probe := range []ProbeConfig{
+		{Command: "exit 0", Interval: "0s"},
+		{Command: "exit 0", Interval: "-1s"},
+		{Command: "exit 0", Timeout: "0s"},
+	} {
+		if errs := (ExecConfig{Health: HealthConfig{Liveness: probe}}).Validate(); len(errs) != 1 {
+			t.Errorf("%+v: expected a non-positive duration error, got %v", probe, errs)
+		}
+	}
+}
+
+func TestChildOutput(t *testing.T) {
+	var buf bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{Output: &buf, JSONFormat: true})
+	exec, err := newExecutorFromConfig("test", "bash -c 'echo first; sleep 0.1; echo second >&2; printf third; exit 3'", ExecConfig{
+		Output: OutputConfig{Mode: OutputLog, Level: "warn", TailLines: 2},
+	}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.SpawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	if !exec.Wait(context.Background()) {
+		t.Error("Wait should return true if the child exits")
+	}
+	exec.StopChild()
+
+	out := buf.String()
+	for _, expected := range []string{
+		`"@level":"warn","@message":"first"`,
+		`"@message":"second"`,
+		`"stream":"stderr"`,
+		`child crashed, last 2 lines of output:\nsecond\nthird"`,
+		`"exit_code":3`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("the output doesn't contain %s:\n%s", expected, out)
+		}
+	}
+}
+
+func TestChildOutputInherit(t *testing.T) {
+	// the lines go to the stdout of the test, the tail is kept anyway
+	o := newChildOutput(OutputConfig{TailLines: 2}, "app", nil)
+	fmt.Fprint(o.Stdout, "first\nsecond\n")
+	fmt.Fprint(o.Stderr, "third\n")
+	if tail := o.Tail(); !reflect.DeepEqual(tail, []string{"second", "third"}) {
+		t.Errorf("unexpected tail %q", tail)
+	}
+}
+
+func TestChildOutputFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "child.log")
+	o := newChildOutput(OutputConfig{Mode: OutputFile, File: file}, "app", nil)
+	fmt.Fprint(o.Stdout, "a line\nan incomplete line")
+	fmt.Fprint(o.Stderr, "an error\r\n")
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := regexp.Match(`^\S+ \[app\] a line\n\S+ \[app\] an error\n\S+ \[app\] an incomplete line\n$`, data); !ok {
+		t.Errorf("unexpected log file content %q", data)
+	}
+	if tail := o.Tail(); len(tail) != 3 {
+		t.Errorf("unexpected tail %q", tail)
+	}
+}
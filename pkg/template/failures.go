@@ -0,0 +1,149 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxFailureLogSize is the number of failures that are kept, older failures are dropped.
+const maxFailureLogSize = 1000
+
+// failureLog records the times of the failures of a resource and its child process.
+type failureLog struct {
+	mu    sync.Mutex
+	times []time.Time
+}
+
+// add records a failure at the time t.
+func (l *failureLog) add(t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.times = append(l.times, t)
+	if len(l.times) > maxFailureLogSize {
+		l.times = l.times[len(l.times)-maxFailureLogSize:]
+	}
+}
+
+// count returns the number of failures after since.
+func (l *failureLog) count(since time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var n int
+	for i := len(l.times) - 1; i >= 0 && l.times[i].After(since); i-- {
+		n++
+	}
+	return n
+}
+
+// FailureAction decides what happens when a resource failed too often.
+type FailureAction string
+
+const (
+	// RetryOnFailure restarts the resource after every failure. This is the default.
+	RetryOnFailure FailureAction = "retry"
+	// ExitOnFailure exits remco.
+	ExitOnFailure FailureAction = "exit"
+	// IgnoreOnFailure stops the resource, the other resources keep running.
+	IgnoreOnFailure FailureAction = "ignore"
+)
+
+// Validate returns an error if a is not a known action.
+func (a FailureAction) Validate() error {
+	switch a {
+	case "", RetryOnFailure, ExitOnFailure, IgnoreOnFailure:
+		return nil
+	}
+	return fmt.Errorf("unknown failure action %q - valid actions are %q, %q and %q", string(a), RetryOnFailure, ExitOnFailure, IgnoreOnFailure)
+}
+
+// The restarts of a failed resource are delayed by an exponential backoff with jitter.
+const (
+	restartBackoffMin = time.Second
+	restartBackoffMax = 30 * time.Second
+)
+
+// DefaultFailureWindow is the default time window in which the failures of a resource are counted.
+const DefaultFailureWindow = 10 * time.Minute
+
+// FailurePolicy is the configuration of the failure handling of a resource.
+type FailurePolicy struct {
+	// OnFailure is applied once the resource failed MaxFailures times within Window.
+	// The resource is restarted until then.
+	OnFailure FailureAction
+	// MaxFailures defaults to 1, it is ignored by RetryOnFailure.
+	MaxFailures int
+	// Window is a duration string like "10m", it defaults to 10 minutes.
+	Window string
+}
+
+// window returns the parsed failure window.
+func (p FailurePolicy) window() (time.Duration, error) {
+	if p.Window == "" {
+		return DefaultFailureWindow, nil
+	}
+	d, err := time.ParseDuration(p.Window)
+	if err != nil {
+		return 0, errors.Wrap(err, "parsing failure_window failed")
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("failure_window must be positive")
+	}
+	return d, nil
+}
+
+// Validate checks the failure policy.
+func (p FailurePolicy) Validate() []ConfigError {
+	var errs []ConfigError
+	if err := p.OnFailure.Validate(); err != nil {
+		errs = append(errs, ConfigError{Key: "on_failure", Err: err})
+	}
+	if p.MaxFailures < 0 {
+		errs = append(errs, ConfigError{Key: "max_failures", Err: fmt.Errorf("max_failures must not be negative")})
+	}
+	if _, err := p.window(); err != nil {
+		errs = append(errs, ConfigError{Key: "failure_window", Err: err})
+	}
+	return errs
+}
+
+// OnFailure returns the action that is applied once the resource failed too often.
+func (t *Resource) OnFailure() FailureAction {
+	if t.failurePolicy.OnFailure == "" {
+		return RetryOnFailure
+	}
+	return t.failurePolicy.OnFailure
+}
+
+// FailureLimitReached reports whether the resource failed max_failures times within the failure window,
+// so that the on_failure action is applied instead of a restart. It is always false for RetryOnFailure.
+func (t *Resource) FailureLimitReached() bool {
+	if t.OnFailure() == RetryOnFailure {
+		return false
+	}
+	max := t.failurePolicy.MaxFailures
+	if max <= 0 {
+		max = 1
+	}
+	return t.Failures(time.Now().Add(-t.failureWindow)) >= max
+}
+
+// RestartDelay returns the time to wait before the failed resource is restarted.
+// The delay doubles with every failure within the failure window.
+func (t *Resource) RestartDelay() time.Duration {
+	attempt := t.Failures(time.Now().Add(-t.failureWindow)) - 1
+	if attempt < 0 {
+		attempt = 0
+	}
+	return jitter(backoff(restartBackoffMin, restartBackoffMax, attempt))
+}
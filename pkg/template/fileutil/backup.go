@@ -0,0 +1,164 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package fileutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+)
+
+// backupTimeFormat is the timestamp format of the backup file names.
+// It sorts lexically in chronological order.
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// Backup is a saved version of a file.
+type Backup struct {
+	// Path is the path of the backup file.
+	Path string
+	// Time is the time the backup was created.
+	Time time.Time
+	// Hash is the sha1 hash of the content.
+	Hash string
+}
+
+// Version returns the version of the backup, which is the name of the backup file.
+func (b Backup) Version() string {
+	return filepath.Base(b.Path)
+}
+
+// backupDir returns the directory in dir that holds the backups of the file at path.
+// The absolute path of the file is recreated below dir, e.g. /etc/app.conf -> dir/etc/app.conf/.
+func backupDir(path, dir string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't get absolute path")
+	}
+	abs = strings.TrimPrefix(abs, filepath.VolumeName(abs))
+	return filepath.Join(dir, abs), nil
+}
+
+// BackupFile saves the current version of the file at path in dir.
+// The backup file is named after the current time and the hash of the content.
+// Only the keep newest backups are kept, older backups are removed.
+// Nothing is saved if the file doesn't exist or the newest backup has the same content.
+func BackupFile(path, dir string, keep int) error {
+	if !IsFileExist(path) {
+		return nil
+	}
+	info, err := stat(path)
+	if err != nil {
+		return err
+	}
+
+	backups, err := ListBackups(path, dir)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 || backups[0].Hash != info.Hash {
+		bdir, err := backupDir(path, dir)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(bdir, 0700); err != nil {
+			return errors.Wrap(err, "couldn't create backup directory")
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrap(err, "couldn't read file")
+		}
+		name := time.Now().UTC().Format(backupTimeFormat) + "-" + info.Hash
+		backup := filepath.Join(bdir, name)
+		if err := ioutil.WriteFile(backup, content, info.Mode); err != nil {
+			return errors.Wrap(err, "couldn't write backup file")
+		}
+		// only root can change the owner, so the errors are ignored
+		os.Chmod(backup, info.Mode)
+		os.Chown(backup, int(info.Uid), int(info.Gid))
+
+		backups, err = ListBackups(path, dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if keep > 0 && len(backups) > keep {
+		for _, b := range backups[keep:] {
+			if err := os.Remove(b.Path); err != nil {
+				return errors.Wrap(err, "couldn't remove old backup")
+			}
+		}
+	}
+	return nil
+}
+
+// Hash returns the sha1 hash of the content of the file at path, like it is used in the backup names.
+func Hash(path string) (string, error) {
+	info, err := stat(path)
+	if err != nil {
+		return "", err
+	}
+	return info.Hash, nil
+}
+
+// ListBackups returns all backups of the file at path in dir, the newest backup first.
+func ListBackups(path, dir string) ([]Backup, error) {
+	bdir, err := backupDir(path, dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(bdir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "couldn't read backup directory")
+	}
+
+	var backups []Backup
+	for _, f := range files {
+		parts := strings.SplitN(f.Name(), "-", 2)
+		if f.IsDir() || len(parts) != 2 {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, parts[0])
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{
+			Path: filepath.Join(bdir, f.Name()),
+			Time: t,
+			Hash: parts[1],
+		})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time.After(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// RestoreBackup replaces the file at path with the backup b.
+// The content, the mode and the owner of the backup are restored.
+func RestoreBackup(b Backup, path string, logger hclog.Logger) error {
+	s, err := TakeSnapshot(b.Path)
+	if err != nil {
+		return err
+	}
+	if !s.exists {
+		return errors.Errorf("backup %s not found", b.Version())
+	}
+	s.path = path
+	return s.Restore(logger)
+}
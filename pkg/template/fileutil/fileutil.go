@@ -64,52 +64,59 @@ func ReplaceFile(src, dest string, mode os.FileMode, logger hclog.Logger) error
 	return nil
 }
 
-// SameFile reports whether src and dest config files are equal.
-// Two config files are equal when they have the same file contents and
-// Unix permissions. The owner, group, and mode must match.
-// It return false in other cases.
-func SameFile(src, dest string, logger hclog.Logger) (bool, error) {
-	if !IsFileExist(dest) {
-		return false, nil
-	}
+// Difference describes a single difference between two files.
+type Difference struct {
+	// Attribute is the name of the differing attribute: UID, GID, filemode or hashsum.
+	Attribute string
+	Current   interface{}
+	New       interface{}
+}
+
+// Compare returns the differences in owner, group, mode and content between the dest and src file.
+// It returns an error if one of the files can't be read.
+func Compare(src, dest string) ([]Difference, error) {
 	d, err := stat(dest)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	s, err := stat(src)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
+	var diffs []Difference
 	if d.Uid != s.Uid {
-		logger.With(
-			"config", dest,
-			"current", d.Uid,
-			"new", s.Uid,
-		).Info("wrong UID")
+		diffs = append(diffs, Difference{Attribute: "UID", Current: d.Uid, New: s.Uid})
 	}
 	if d.Gid != s.Gid {
-		logger.With(
-			"config", dest,
-			"current", d.Gid,
-			"new", s.Gid,
-		).Info("wrong GID")
+		diffs = append(diffs, Difference{Attribute: "GID", Current: d.Gid, New: s.Gid})
 	}
 	if d.Mode != s.Mode {
-		logger.With(
-			"config", dest,
-			"current", d.Mode,
-			"new", s.Mode,
-		).Info("wrong filemode")
+		diffs = append(diffs, Difference{Attribute: "filemode", Current: d.Mode, New: s.Mode})
 	}
 	if d.Hash != s.Hash {
-		logger.With(
-			"config", dest,
-			"current", d.Hash,
-			"new", s.Hash,
-		).Info("wrong hashsum")
+		diffs = append(diffs, Difference{Attribute: "hashsum", Current: d.Hash, New: s.Hash})
 	}
-	if d.Uid != s.Uid || d.Gid != s.Gid || d.Mode != s.Mode || d.Hash != s.Hash {
+	return diffs, nil
+}
+
+// SameFile reports whether src and dest config files are equal.
+// Two config files are equal when they have the same file contents and
+// Unix permissions. The owner, group, and mode must match.
+// It return false in other cases.
+func SameFile(src, dest string, logger hclog.Logger) (bool, error) {
+	if !IsFileExist(dest) {
 		return false, nil
 	}
-	return true, nil
+	diffs, err := Compare(src, dest)
+	if err != nil {
+		return false, err
+	}
+	for _, d := range diffs {
+		logger.With(
+			"config", dest,
+			"current", d.Current,
+			"new", d.New,
+		).Info("wrong " + d.Attribute)
+	}
+	return len(diffs) == 0, nil
 }
@@ -12,6 +12,7 @@ import (
 	"github.com/hashicorp/go-hclog"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	. "gopkg.in/check.v1"
@@ -108,3 +109,48 @@ func (s *TestSuite) TestReplaceFile(t *C) {
 		t.Error(err.Error())
 	}
 }
+
+func (s *TestSuite) TestCompare(t *C) {
+	diffs, err := Compare(s.file.Name(), s.sameFile.Name())
+	t.Check(err, IsNil)
+	t.Check(diffs, HasLen, 0)
+
+	diffs, err = Compare(s.file.Name(), s.differentHash.Name())
+	t.Check(err, IsNil)
+	t.Assert(diffs, HasLen, 1)
+	t.Check(diffs[0].Attribute, Equals, "hashsum")
+}
+
+func (s *TestSuite) TestBackupFile(t *C) {
+	dir := t.MkDir()
+	backupDir := filepath.Join(dir, "backup")
+	file := filepath.Join(dir, "app.conf")
+
+	// a missing file is not saved
+	t.Assert(BackupFile(file, backupDir, 2), IsNil)
+	backups, err := ListBackups(file, backupDir)
+	t.Assert(err, IsNil)
+	t.Check(backups, HasLen, 0)
+
+	for _, content := range []string{"v1", "v2", "v2", "v3"} {
+		t.Assert(ioutil.WriteFile(file, []byte(content), 0640), IsNil)
+		t.Assert(BackupFile(file, backupDir, 2), IsNil)
+	}
+
+	// unchanged content is saved once and only the 2 newest backups are kept
+	backups, err = ListBackups(file, backupDir)
+	t.Assert(err, IsNil)
+	t.Assert(backups, HasLen, 2)
+	t.Check(filepath.Dir(backups[0].Path), Equals, filepath.Join(backupDir, file))
+	data, err := ioutil.ReadFile(backups[1].Path)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "v2")
+
+	t.Assert(RestoreBackup(backups[1], file, hclog.Default()), IsNil)
+	data, err = ioutil.ReadFile(file)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "v2")
+	fi, err := os.Stat(file)
+	t.Assert(err, IsNil)
+	t.Check(fi.Mode().Perm(), Equals, os.FileMode(0640))
+}
@@ -0,0 +1,85 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package fileutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/pkg/errors"
+)
+
+// Snapshot holds the content and the attributes of a file at a given time.
+// It is used to restore a file that was replaced.
+type Snapshot struct {
+	path    string
+	exists  bool
+	content []byte
+	info    fileInfo
+}
+
+// TakeSnapshot saves the current state of the file at path.
+// The file doesn't need to exist, restoring the snapshot removes it in this case.
+func TakeSnapshot(path string) (*Snapshot, error) {
+	s := &Snapshot{path: path}
+	if !IsFileExist(path) {
+		return s, nil
+	}
+
+	info, err := stat(path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read file")
+	}
+	s.exists = true
+	s.content = content
+	s.info = info
+	return s, nil
+}
+
+// Content returns the saved content of the file.
+func (s *Snapshot) Content() []byte {
+	return s.content
+}
+
+// Restore restores the saved state of the file.
+func (s *Snapshot) Restore(logger hclog.Logger) error {
+	if !s.exists {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "couldn't remove file")
+		}
+		return nil
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(s.path), "."+filepath.Base(s.path))
+	if err != nil {
+		return errors.Wrap(err, "couldn't create temp file")
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = temp.Write(s.content)
+	temp.Close()
+	if err != nil {
+		return errors.Wrap(err, "couldn't write temp file")
+	}
+	// only root can change the owner, so the errors are ignored
+	os.Chmod(temp.Name(), s.info.Mode)
+	os.Chown(temp.Name(), int(s.info.Uid), int(s.info.Gid))
+
+	if err := ReplaceFile(temp.Name(), s.path, s.info.Mode, logger); err != nil {
+		return err
+	}
+	os.Chown(s.path, int(s.info.Uid), int(s.info.Gid))
+	return nil
+}
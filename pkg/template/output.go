@@ -0,0 +1,239 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	defaultOutputMaxSize   = 10
+	defaultOutputMaxFiles  = 5
+	defaultOutputTailLines = 20
+
+	// maxLineLength is the maximum length of a line, longer lines are split.
+	maxLineLength = 64 * 1024
+)
+
+// OutputMode decides where the output of a child process is written to.
+type OutputMode string
+
+const (
+	// OutputInherit writes the output unchanged to the stdout and stderr of remco.
+	OutputInherit OutputMode = "inherit"
+	// OutputPrefix writes every line prefixed with the resource name to the stdout and stderr of remco.
+	OutputPrefix OutputMode = "prefix"
+	// OutputLog writes every line as a log message of remco.
+	OutputLog OutputMode = "log"
+	// OutputFile writes every line prefixed with the time and the resource name to a rotating log file.
+	OutputFile OutputMode = "file"
+)
+
+// Validate returns an error if m is not a known output mode.
+func (m OutputMode) Validate() error {
+	switch m {
+	case "", OutputInherit, OutputPrefix, OutputLog, OutputFile:
+		return nil
+	}
+	return fmt.Errorf("unknown output mode %q - valid modes are %q, %q, %q and %q", string(m), OutputInherit, OutputPrefix, OutputLog, OutputFile)
+}
+
+// OutputConfig configures the handling of the stdout and stderr of the child process.
+type OutputConfig struct {
+	// Mode is "inherit", "prefix", "log" or "file". Defaults to "inherit".
+	Mode OutputMode `json:"mode"`
+
+	// Level is the log level of the lines in the log mode. Defaults to "info".
+	Level string `json:"level"`
+
+	// File is the log file in the file mode.
+	File string `json:"file"`
+
+	// MaxSize is the size in megabytes after which the log file is rotated. Defaults to 10.
+	MaxSize int `toml:"max_size" json:"max_size"`
+
+	// MaxFiles is the number of rotated log files to keep. Defaults to 5.
+	MaxFiles int `toml:"max_files" json:"max_files"`
+
+	// TailLines is the number of lines that are kept and logged if the child process crashes.
+	// Defaults to 20.
+	TailLines int `toml:"tail_lines" json:"tail_lines"`
+}
+
+// Validate checks the output configuration.
+func (c OutputConfig) Validate() []ConfigError {
+	var errs []ConfigError
+	if err := c.Mode.Validate(); err != nil {
+		errs = append(errs, ConfigError{Key: "output.mode", Err: err})
+	}
+	if c.Level != "" && hclog.LevelFromString(c.Level) == hclog.NoLevel {
+		errs = append(errs, ConfigError{Key: "output.level", Err: fmt.Errorf("unknown log level %q", c.Level)})
+	}
+	if c.Mode == OutputFile && c.File == "" {
+		errs = append(errs, ConfigError{Key: "output.file", Err: fmt.Errorf("the output mode %q requires a file", OutputFile)})
+	}
+	for _, v := range []struct {
+		key   string
+		value int
+	}{{"max_size", c.MaxSize}, {"max_files", c.MaxFiles}, {"tail_lines", c.TailLines}} {
+		if v.value < 0 {
+			errs = append(errs, ConfigError{Key: "output." + v.key, Err: fmt.Errorf("must not be negative")})
+		}
+	}
+	return errs
+}
+
+// childOutput receives the output of a child process.
+// It keeps the last lines of both streams.
+type childOutput struct {
+	Stdout io.Writer
+	Stderr io.Writer
+
+	lines []*lineWriter
+	file  *lumberjack.Logger
+
+	mu   sync.Mutex
+	tail []string
+	max  int
+}
+
+// newChildOutput creates the writers for the stdout and stderr of the child process of the resource name.
+func newChildOutput(c OutputConfig, name string, logger hclog.Logger) *childOutput {
+	o := &childOutput{max: c.TailLines}
+	if o.max == 0 {
+		o.max = defaultOutputTailLines
+	}
+
+	var stdout, stderr func(line string)
+	switch c.Mode {
+	case OutputPrefix:
+		stdout = func(line string) { fmt.Fprintf(os.Stdout, "[%s] %s\n", name, line) }
+		stderr = func(line string) { fmt.Fprintf(os.Stderr, "[%s] %s\n", name, line) }
+	case OutputLog:
+		level := hclog.Info
+		if c.Level != "" {
+			level = hclog.LevelFromString(c.Level)
+		}
+		stdout = func(line string) { logger.Log(level, line, "stream", "stdout") }
+		stderr = func(line string) { logger.Log(level, line, "stream", "stderr") }
+	case OutputFile:
+		o.file = &lumberjack.Logger{
+			Filename:   c.File,
+			MaxSize:    c.MaxSize,
+			MaxBackups: c.MaxFiles,
+		}
+		if o.file.MaxSize == 0 {
+			o.file.MaxSize = defaultOutputMaxSize
+		}
+		if o.file.MaxBackups == 0 {
+			o.file.MaxBackups = defaultOutputMaxFiles
+		}
+		var mu sync.Mutex
+		write := func(line string) {
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintf(o.file, "%s [%s] %s\n", time.Now().Format(time.RFC3339), name, line)
+		}
+		stdout, stderr = write, write
+	default:
+		// the output is written unchanged to remco, the lines are only kept for the tail
+		o.Stdout = io.MultiWriter(os.Stdout, o.newLineWriter(func(string) {}))
+		o.Stderr = io.MultiWriter(os.Stderr, o.newLineWriter(func(string) {}))
+		return o
+	}
+	o.Stdout = o.newLineWriter(stdout)
+	o.Stderr = o.newLineWriter(stderr)
+	return o
+}
+
+func (o *childOutput) newLineWriter(handle func(line string)) *lineWriter {
+	w := &lineWriter{handle: func(line string) {
+		o.keep(line)
+		handle(line)
+	}}
+	o.lines = append(o.lines, w)
+	return w
+}
+
+// keep adds the line to the tail.
+func (o *childOutput) keep(line string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.tail = append(o.tail, line)
+	if len(o.tail) > o.max {
+		o.tail = o.tail[len(o.tail)-o.max:]
+	}
+}
+
+// Tail returns the last lines of the output.
+func (o *childOutput) Tail() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string(nil), o.tail...)
+}
+
+// Flush writes incomplete lines, e.g. after the child process exited.
+func (o *childOutput) Flush() {
+	for _, w := range o.lines {
+		w.flush()
+	}
+}
+
+// Close writes incomplete lines and closes the log file.
+func (o *childOutput) Close() error {
+	o.Flush()
+	if o.file != nil {
+		return o.file.Close()
+	}
+	return nil
+}
+
+// lineWriter is an io.Writer that calls handle for every written line.
+type lineWriter struct {
+	mu     sync.Mutex
+	buf    []byte
+	handle func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.handle(string(bytes.TrimSuffix(w.buf[:i], []byte("\r"))))
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) >= maxLineLength {
+		w.handle(string(w.buf))
+		w.buf = nil
+	}
+	return len(p), nil
+}
+
+// flush handles the last line if it doesn't end with a newline.
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.handle(string(w.buf))
+		w.buf = nil
+	}
+}
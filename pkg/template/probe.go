@@ -0,0 +1,245 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultProbeInterval         = time.Second
+	defaultProbeTimeout          = time.Second
+	defaultProbeFailureThreshold = 3
+)
+
+// childPidEnv is the environment variable that holds the pid of the checked child process in command probes.
+const childPidEnv = "REMCO_CHILD_PID"
+
+// ProbeConfig is a check of the child process.
+// One of TCP, HTTP or Command must be set.
+type ProbeConfig struct {
+	// TCP is an address like "localhost:8080" that must accept connections.
+	TCP string `json:"tcp"`
+
+	// HTTP is an URL that must respond to a GET request with a 2xx or 3xx status code.
+	HTTP string `json:"http"`
+
+	// Command is a shell command that must exit with 0.
+	// The pid of the checked child process is set in $REMCO_CHILD_PID.
+	Command string `json:"command"`
+
+	// Interval is the time between two checks. Defaults to 1s.
+	Interval string `json:"interval"`
+
+	// Timeout is the maximum duration of a single check. Defaults to 1s.
+	Timeout string `json:"timeout"`
+
+	// FailureThreshold is the number of consecutive failed checks after which the child is considered unhealthy.
+	// Defaults to 3.
+	FailureThreshold int `toml:"failure_threshold" json:"failure_threshold"`
+
+	// InitialDelay is the time to wait after the child was started before the first check, e.g. "5s".
+	InitialDelay string `toml:"initial_delay" json:"initial_delay"`
+}
+
+// enabled reports whether a check is configured.
+func (p ProbeConfig) enabled() bool {
+	return p.TCP != "" || p.HTTP != "" || p.Command != ""
+}
+
+// durations returns the parsed interval and timeout.
+// Both must be positive, a zero interval would run the checks back to back.
+func (p ProbeConfig) durations() (time.Duration, time.Duration, error) {
+	interval, timeout := defaultProbeInterval, defaultProbeTimeout
+	var err error
+	if p.Interval != "" {
+		if interval, err = time.ParseDuration(p.Interval); err != nil {
+			return 0, 0, errors.Wrap(err, "parsing interval failed")
+		}
+		if interval <= 0 {
+			return 0, 0, fmt.Errorf("the interval %q must be positive", p.Interval)
+		}
+	}
+	if p.Timeout != "" {
+		if timeout, err = time.ParseDuration(p.Timeout); err != nil {
+			return 0, 0, errors.Wrap(err, "parsing timeout failed")
+		}
+		if timeout <= 0 {
+			return 0, 0, fmt.Errorf("the timeout %q must be positive", p.Timeout)
+		}
+	}
+	return interval, timeout, nil
+}
+
+// Validate checks the probe configuration.
+// prefix is prepended to every reported key.
+func (p ProbeConfig) Validate(prefix string) []ConfigError {
+	var errs []ConfigError
+	n := 0
+	for _, v := range []string{p.TCP, p.HTTP, p.Command} {
+		if v != "" {
+			n++
+		}
+	}
+	if n > 1 {
+		errs = append(errs, ConfigError{Key: prefix, Err: fmt.Errorf("only one of tcp, http and command can be set")})
+	}
+	if _, _, err := p.durations(); err != nil {
+		errs = append(errs, ConfigError{Key: prefix, Err: err})
+	}
+	if _, err := p.initialDelay(); err != nil {
+		errs = append(errs, ConfigError{Key: prefix + ".initial_delay", Err: err})
+	}
+	if p.FailureThreshold < 0 {
+		errs = append(errs, ConfigError{Key: prefix + ".failure_threshold", Err: fmt.Errorf("must not be negative")})
+	}
+	return errs
+}
+
+// initialDelay returns the parsed initial delay.
+func (p ProbeConfig) initialDelay() (time.Duration, error) {
+	if p.InitialDelay == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(p.InitialDelay)
+	if err != nil {
+		return 0, errors.Wrap(err, "parsing initial_delay failed")
+	}
+	return d, nil
+}
+
+// failureThreshold returns the failure threshold or the default value.
+func (p ProbeConfig) failureThreshold() int {
+	if p.FailureThreshold <= 0 {
+		return defaultProbeFailureThreshold
+	}
+	return p.FailureThreshold
+}
+
+// check runs the probe once against the child process with the pid.
+// It returns nil if the check succeeded.
+func (p ProbeConfig) check(ctx context.Context, timeout time.Duration, pid int) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch {
+	case p.TCP != "":
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", p.TCP)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case p.HTTP != "":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.HTTP, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+		return nil
+	case p.Command != "":
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", p.Command)
+		cmd.Env = append(os.Environ(), childPidEnv+"="+strconv.Itoa(pid))
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return errors.Wrapf(err, "%q", string(output))
+		}
+		return nil
+	}
+	return nil
+}
+
+// waitUntilReady runs the probe against the child process with the pid every interval until it succeeds.
+// It returns an error if the probe doesn't succeed within timeout or the context is canceled.
+func (p ProbeConfig) waitUntilReady(ctx context.Context, timeout time.Duration, pid int) error {
+	if !p.enabled() {
+		return nil
+	}
+	interval, checkTimeout, err := p.durations()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		err := p.check(ctx, checkTimeout, pid)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(err, "readiness check failed")
+		case <-time.After(interval):
+		}
+	}
+}
+
+// run runs the probe every interval until the context is canceled.
+// pid returns the pid of the current child process.
+// healthy returns the current state of the child.
+// onChange is called whenever the state changes: if the probe succeeds while the child is unhealthy,
+// and if failureThreshold consecutive checks failed while the child is healthy.
+func (p ProbeConfig) run(ctx context.Context, pid func() int, healthy func() bool, onChange func(healthy bool, err error)) {
+	interval, timeout, err := p.durations()
+	if err != nil {
+		return
+	}
+	delay, err := p.initialDelay()
+	if err != nil {
+		return
+	}
+	threshold := p.failureThreshold()
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(delay):
+	}
+
+	failures := 0
+	for {
+		err := p.check(ctx, timeout, pid())
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			failures = 0
+			if !healthy() {
+				onChange(true, nil)
+			}
+		} else {
+			failures++
+			if failures >= threshold && healthy() {
+				onChange(false, err)
+				failures = 0
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
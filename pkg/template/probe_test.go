@@ -0,0 +1,50 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProbeCheck(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		probe ProbeConfig
+		ok    bool
+	}{
+		{ProbeConfig{TCP: l.Addr().String()}, true},
+		{ProbeConfig{HTTP: srv.URL + "/ready"}, true},
+		{ProbeConfig{HTTP: srv.URL + "/starting"}, false},
+		{ProbeConfig{Command: "exit 0"}, true},
+		{ProbeConfig{Command: "exit 1"}, false},
+		{ProbeConfig{Command: "test \"$REMCO_CHILD_PID\" = 42"}, true},
+	} {
+		err := tc.probe.check(context.Background(), time.Second, 42)
+		if (err == nil) != tc.ok {
+			t.Errorf("%+v: unexpected result %v", tc.probe, err)
+		}
+	}
+}
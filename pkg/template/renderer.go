@@ -14,37 +14,67 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/hashicorp/go-hclog"
+	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"text/template"
 	"time"
 
 	"github.com/HeavyHorst/pongo2"
+	"github.com/HeavyHorst/remco/pkg/child"
 	"github.com/HeavyHorst/remco/pkg/template/fileutil"
 	"github.com/armon/go-metrics"
 	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
 )
 
 func init() {
 	pongo2.SetAutoescape(false)
 }
 
+// DefaultBackupKeep is the default number of backups per dst file.
+const DefaultBackupKeep = 10
+
 // Renderer contains all data needed for the template processing
 type Renderer struct {
-	Src       string `json:"src"`
-	Dst       string `json:"dst"`
-	MkDirs    bool   `toml:"make_directories"`
-	Mode      string `json:"mode"`
-	UID       int    `json:"uid"`
-	GID       int    `json:"gid"`
-	ReloadCmd string `toml:"reload_cmd" json:"reload_cmd"`
-	CheckCmd  string `toml:"check_cmd" json:"check_cmd"`
+	Src       string  `json:"src"`
+	Dst       string  `json:"dst"`
+	MkDirs    bool    `toml:"make_directories"`
+	Mode      string  `json:"mode"`
+	UID       int     `json:"uid"`
+	GID       int     `json:"gid"`
+	ReloadCmd Command `toml:"reload_cmd" json:"reload_cmd"`
+	CheckCmd  Command `toml:"check_cmd" json:"check_cmd"`
+
+	// CheckTimeout is the maximum duration of the check_cmd, e.g. "30s".
+	// The check fails if the command takes longer. By default there is no timeout.
+	CheckTimeout string `toml:"check_timeout" json:"check_timeout"`
+	// ReloadTimeout is the maximum duration of the reload_cmd, e.g. "30s".
+	// The reload fails if the command takes longer. By default there is no timeout.
+	ReloadTimeout string `toml:"reload_timeout" json:"reload_timeout"`
+
+	// CmdUser is the user name or uid the check_cmd and the reload_cmd run as.
+	CmdUser string `toml:"cmd_user" json:"cmd_user"`
+	// CmdGroup is the group name or gid the commands run as. Defaults to the primary group of CmdUser.
+	CmdGroup string `toml:"cmd_group" json:"cmd_group"`
+
+	// BackupDir enables the backups of the dst file.
+	// The current dst file is saved in BackupDir before it is replaced.
+	BackupDir string `toml:"backup_dir" json:"backup_dir"`
+	// BackupKeep is the number of backups to keep. Defaults to 10.
+	BackupKeep int `toml:"backup_keep" json:"backup_keep"`
+
+	// previousHash and newHash are the hashes of the dst file before and after the render.
+	previousHash string
+	newHash      string
+
 	stageFile *os.File
+	previous  *fileutil.Snapshot
 	logger    hclog.Logger
 	ReapLock  *sync.RWMutex
 }
@@ -52,8 +82,9 @@ type Renderer struct {
 // createStageFile stages the src configuration file by processing the src
 // template and setting the desired owner, group, and mode. It also sets the
 // StageFile for the template resource.
+// In dry-run mode the stage file is created in the temp directory and no directories are created.
 // It returns an error if any.
-func (s *Renderer) createStageFile(funcMap map[string]interface{}) error {
+func (s *Renderer) createStageFile(funcMap map[string]interface{}, dryRun bool) error {
 	if !fileutil.IsFileExist(s.Src) {
 		return fmt.Errorf("missing template: %s", s.Src)
 	}
@@ -62,23 +93,21 @@ func (s *Renderer) createStageFile(funcMap map[string]interface{}) error {
 		"template", s.Src,
 	).Debug("compiling source template")
 
-	set := pongo2.NewSet("local", &pongo2.LocalFilesystemLoader{})
-	set.Options = &pongo2.Options{
-		TrimBlocks:   true,
-		LStripBlocks: true,
-	}
-	tmpl, err := set.FromFile(s.Src)
+	tmpl, err := s.compile()
 	if err != nil {
-		return errors.Wrapf(err, "set.FromFile(%s) failed", s.Src)
+		return err
 	}
 
 	// create TempFile in Dest directory to avoid cross-filesystem issues
-	if s.MkDirs {
+	stageDir := filepath.Dir(s.Dst)
+	if dryRun {
+		stageDir = os.TempDir()
+	} else if s.MkDirs {
 		if err := os.MkdirAll(filepath.Dir(s.Dst), 0755); err != nil {
 			return errors.Wrap(err, "MkdirAll failed")
 		}
 	}
-	temp, err := ioutil.TempFile(filepath.Dir(s.Dst), "."+filepath.Base(s.Dst))
+	temp, err := ioutil.TempFile(stageDir, "."+filepath.Base(s.Dst))
 	if err != nil {
 		return errors.Wrap(err, "couldn't create tempfile")
 	}
@@ -107,15 +136,65 @@ func (s *Renderer) createStageFile(funcMap map[string]interface{}) error {
 	return nil
 }
 
-// syncFiles compares the staged and dest config files and attempts to sync them
-// if they differ. syncFiles will run a config check command if set before
-// overwriting the target config file. Finally, syncFile will run a reload command
-// if set to have the application or service pick up the changes.
-// It returns a boolean indicating if the file has changed and an error if any.
-func (s *Renderer) syncFiles(runCommands bool) (bool, error) {
-	var changed bool
+// compile compiles the src template.
+func (s *Renderer) compile() (*pongo2.Template, error) {
+	set := pongo2.NewSet("local", &pongo2.LocalFilesystemLoader{})
+	set.Options = &pongo2.Options{
+		TrimBlocks:   true,
+		LStripBlocks: true,
+	}
+	tmpl, err := set.FromFile(s.Src)
+	if err != nil {
+		return nil, errors.Wrapf(err, "set.FromFile(%s) failed", s.Src)
+	}
+	return tmpl, nil
+}
+
+// Validate checks the template configuration without rendering anything.
+// It reports an empty, missing or uncompilable src template and an invalid file mode.
+func (s *Renderer) Validate() []ConfigError {
+	var errs []ConfigError
+	if s.Src == "" {
+		errs = append(errs, ConfigError{Key: "src", Err: ErrEmptySrc})
+	} else if !fileutil.IsFileExist(s.Src) {
+		errs = append(errs, ConfigError{Key: "src", Err: fmt.Errorf("missing template: %s", s.Src)})
+	} else if _, err := s.compile(); err != nil {
+		errs = append(errs, ConfigError{Key: "src", Err: err})
+	}
+
+	if s.Dst == "" {
+		errs = append(errs, ConfigError{Key: "dst", Err: fmt.Errorf("empty dst")})
+	}
+
+	if s.Mode != "" {
+		if _, err := parseFileMode(s.Mode); err != nil {
+			errs = append(errs, ConfigError{Key: "mode", Err: err})
+		}
+	}
+
+	if _, err := s.commandAttr(); err != nil {
+		errs = append(errs, ConfigError{Key: "cmd_user", Err: err})
+	}
+
+	if _, err := parseTimeout(s.CheckTimeout); err != nil {
+		errs = append(errs, ConfigError{Key: "check_timeout", Err: err})
+	}
+	if _, err := parseTimeout(s.ReloadTimeout); err != nil {
+		errs = append(errs, ConfigError{Key: "reload_timeout", Err: err})
+	}
+
+	if s.BackupKeep < 0 {
+		errs = append(errs, ConfigError{Key: "backup_keep", Err: fmt.Errorf("must not be negative")})
+	}
+	return errs
+}
+
+// prepare compares the staged and dest config files.
+// If they differ, prepare runs the config check command against the staged file.
+// The environment variables env describe the change to the check command.
+// It returns a boolean indicating if the dest file needs to be replaced and an error if any.
+func (s *Renderer) prepare(runCommands bool, env []string) (bool, error) {
 	staged := s.stageFile.Name()
-	defer os.Remove(staged)
 
 	s.logger.With(
 		"staged", path.Base(staged),
@@ -127,50 +206,176 @@ func (s *Renderer) syncFiles(runCommands bool) (bool, error) {
 		s.logger.Error(err.Error())
 	}
 
-	if !ok {
+	if ok {
 		s.logger.With(
 			"config", s.Dst,
-		).Info("target config out of sync")
+		).Debug("target config in sync")
+		return false, nil
+	}
+
+	s.logger.With(
+		"config", s.Dst,
+	).Info("target config out of sync")
 
-		if runCommands {
-			if err := s.check(staged); err != nil {
-				return changed, errors.Wrap(err, "config check failed")
-			}
+	s.previousHash = ""
+	if fileutil.IsFileExist(s.Dst) {
+		if s.previousHash, err = fileutil.Hash(s.Dst); err != nil {
+			return false, errors.Wrap(err, "hashing the target config failed")
 		}
+	}
+	if s.newHash, err = fileutil.Hash(staged); err != nil {
+		return false, errors.Wrap(err, "hashing the staged config failed")
+	}
 
-		s.logger.With(
-			"config", s.Dst,
-		).Debug("overwriting target config")
+	if runCommands {
+		if err := s.check(staged, env); err != nil {
+			return false, errors.Wrap(err, "config check failed")
+		}
+	}
+	return true, nil
+}
 
-		fileMode, err := s.getFileMode()
-		if err != nil {
-			return changed, errors.Wrap(err, "getFileMode failed")
+// install replaces the dest config file with the staged file.
+// The previous state of the dest file is saved, so that the change can be undone with rollback.
+// It returns an error if any.
+func (s *Renderer) install() error {
+	s.logger.With(
+		"config", s.Dst,
+	).Debug("overwriting target config")
+
+	fileMode, err := s.getFileMode()
+	if err != nil {
+		return errors.Wrap(err, "getFileMode failed")
+	}
+	if s.BackupDir != "" {
+		keep := s.BackupKeep
+		if keep <= 0 {
+			keep = DefaultBackupKeep
 		}
-		if err := fileutil.ReplaceFile(staged, s.Dst, fileMode, s.logger); err != nil {
-			return changed, errors.Wrap(err, "replace file failed")
+		if err := fileutil.BackupFile(s.Dst, s.BackupDir, keep); err != nil {
+			return errors.Wrap(err, "backup failed")
 		}
+	}
+	previous, err := fileutil.TakeSnapshot(s.Dst)
+	if err != nil {
+		return errors.Wrap(err, "saving the target config failed")
+	}
+	if err := fileutil.ReplaceFile(s.stageFile.Name(), s.Dst, fileMode, s.logger); err != nil {
+		return errors.Wrap(err, "replace file failed")
+	}
+	s.previous = previous
 
-		// make sure owner and group match the temp file, in case the file was created with WriteFile
-		os.Chown(s.Dst, s.UID, s.GID)
-		changed = true
+	// make sure owner and group match the temp file, in case the file was created with WriteFile
+	os.Chown(s.Dst, s.UID, s.GID)
+	return nil
+}
 
-		if runCommands {
-			if err := s.reload(s.Dst); err != nil {
-				return changed, errors.Wrap(err, "reload command failed")
-			}
-		}
+// rollback restores the dest config file that was replaced by the last install.
+// It returns an error if any.
+func (s *Renderer) rollback() error {
+	if s.previous == nil {
+		return nil
+	}
+	if err := s.previous.Restore(s.logger); err != nil {
+		return errors.Wrap(err, "restoring the previous config failed")
+	}
+	s.logger.With(
+		"config", s.Dst,
+	).Info("target config has been rolled back")
+	return nil
+}
 
-		s.logger.With(
-			"config", s.Dst,
-		).Info("target config has been updated")
+// removeStageFile removes the staged file, if it wasn't installed.
+func (s *Renderer) removeStageFile() {
+	if s.stageFile != nil {
+		os.Remove(s.stageFile.Name())
+		s.stageFile = nil
+	}
+}
 
+// diffOutputMutex serializes the diffs of all resources.
+var diffOutputMutex sync.Mutex
+
+// diffFiles compares the staged and dest config files like prepare,
+// but writes a unified diff to w instead of replacing the dest file.
+// Differences of the owner, group and mode are written as comments in front of the diff.
+// No commands are executed.
+// It returns a boolean indicating if the file would change and an error if any.
+func (s *Renderer) diffFiles(w io.Writer) (bool, error) {
+	staged := s.stageFile.Name()
+
+	var diffs []fileutil.Difference
+	current := ""
+	from := s.Dst
+	if fileutil.IsFileExist(s.Dst) {
+		var err error
+		diffs, err = fileutil.Compare(staged, s.Dst)
+		if err != nil {
+			return false, errors.Wrap(err, "compare failed")
+		}
+		if len(diffs) == 0 {
+			s.logger.With(
+				"config", s.Dst,
+			).Debug("target config in sync")
+			return false, nil
+		}
+		buf, err := ioutil.ReadFile(s.Dst)
+		if err != nil {
+			return false, errors.Wrap(err, "couldn't read dest file")
+		}
+		current = string(buf)
 	} else {
-		s.logger.With(
-			"config", s.Dst,
-		).Debug("target config in sync")
+		from = "/dev/null"
+	}
 
+	rendered, err := ioutil.ReadFile(staged)
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't read stage file")
 	}
-	return changed, nil
+
+	s.logger.With(
+		"config", s.Dst,
+	).Info("target config out of sync (dry-run)")
+
+	// the diff is written with a single call, so that the diffs of parallel resources don't interleave
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "# %s\n", s.Dst)
+	for _, d := range diffs {
+		if d.Attribute == "hashsum" {
+			continue
+		}
+		fmt.Fprintf(&out, "# %s: %v -> %v\n", d.Attribute, d.Current, d.New)
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(current),
+		B:        splitLines(string(rendered)),
+		FromFile: from,
+		ToFile:   s.Dst,
+		Context:  3,
+	})
+	if err != nil {
+		return true, errors.Wrap(err, "creating diff failed")
+	}
+	out.WriteString(diff)
+	diffOutputMutex.Lock()
+	defer diffOutputMutex.Unlock()
+	_, err = w.Write(out.Bytes())
+	return true, err
+}
+
+// splitLines splits s into lines for the unified diff.
+// Every line ends with a newline, a missing newline at the end of s is added.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if last := len(lines) - 1; lines[last] == "" {
+		lines = lines[:last]
+	} else {
+		lines[last] += "\n"
+	}
+	return lines
 }
 
 func (s *Renderer) getFileMode() (os.FileMode, error) {
@@ -184,29 +389,41 @@ func (s *Renderer) getFileMode() (os.FileMode, error) {
 		}
 		return fi.Mode(), nil
 	}
-	mode, err := strconv.ParseUint(s.Mode, 0, 32)
+	return parseFileMode(s.Mode)
+}
+
+func parseFileMode(m string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(m, 0, 32)
 	if err != nil {
-		return 0, errors.Wrapf(err, "parsing filemode failed: %s", s.Mode)
+		return 0, errors.Wrapf(err, "parsing filemode failed: %s", m)
 	}
 	return os.FileMode(mode), nil
-
 }
 
 // check executes the check command to validate the staged config file. The
 // command is modified so that any references to src template are substituted
 // with a string representing the full path of the staged file. This allows the
 // check to be run on the staged file before overwriting the destination config file.
+// The environment variables env and the hashes of the dst file are passed to the command.
 // It returns nil if the check command returns 0 and there are no other errors.
-func (s *Renderer) check(stageFile string) error {
-	if s.CheckCmd == "" {
+func (s *Renderer) check(stageFile string, env []string) error {
+	if s.CheckCmd.IsEmpty() {
 		return nil
 	}
 	defer metrics.MeasureSince([]string{"files", "check_command_duration"}, time.Now())
-	cmd, err := renderTemplate(s.CheckCmd, map[string]string{"src": stageFile})
+	cmd, err := s.CheckCmd.render(map[string]string{"src": stageFile})
 	if err != nil {
 		return errors.Wrap(err, "rendering check command failed")
 	}
-	output, err := execCommand(cmd, s.logger, s.ReapLock)
+	attr, err := s.commandAttr()
+	if err != nil {
+		return err
+	}
+	timeout, err := parseTimeout(s.CheckTimeout)
+	if err != nil {
+		return errors.Wrap(err, "parsing check_timeout failed")
+	}
+	output, err := execCommand(cmd, s.commandEnv(env), attr, timeout, s.logger, s.ReapLock)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("%q", string(output)))
 		return errors.Wrap(err, "the check command failed")
@@ -216,17 +433,26 @@ func (s *Renderer) check(stageFile string) error {
 }
 
 // reload executes the reload command.
+// The environment variables env and the hashes of the dst file are passed to the command.
 // It returns nil if the reload command returns 0 and an error otherwise.
-func (s *Renderer) reload(renderedFile string) error {
-	if s.ReloadCmd == "" {
+func (s *Renderer) reload(renderedFile string, env []string) error {
+	if s.ReloadCmd.IsEmpty() {
 		return nil
 	}
 	defer metrics.MeasureSince([]string{"files", "reload_command_duration"}, time.Now())
-	cmd, err := renderTemplate(s.ReloadCmd, map[string]string{"dst": renderedFile})
+	cmd, err := s.ReloadCmd.render(map[string]string{"dst": renderedFile})
 	if err != nil {
 		return errors.Wrap(err, "rendering reload command failed")
 	}
-	output, err := execCommand(cmd, s.logger, s.ReapLock)
+	attr, err := s.commandAttr()
+	if err != nil {
+		return err
+	}
+	timeout, err := parseTimeout(s.ReloadTimeout)
+	if err != nil {
+		return errors.Wrap(err, "parsing reload_timeout failed")
+	}
+	output, err := execCommand(cmd, s.commandEnv(env), attr, timeout, s.logger, s.ReapLock)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("%q", string(output)))
 		return errors.Wrap(err, "the reload command failed")
@@ -235,6 +461,32 @@ func (s *Renderer) reload(renderedFile string) error {
 	return nil
 }
 
+// commandEnv returns env with the dst file and its hashes.
+func (s *Renderer) commandEnv(env []string) []string {
+	return append(env[:len(env):len(env)],
+		EnvDst+"="+s.Dst,
+		EnvPreviousHash+"="+s.previousHash,
+		EnvNewHash+"="+s.newHash,
+	)
+}
+
+// commandAttr returns the process attributes of the check and reload commands.
+func (s *Renderer) commandAttr() (child.Attr, error) {
+	var attr child.Attr
+	if s.CmdUser == "" {
+		if s.CmdGroup != "" {
+			return attr, fmt.Errorf("cmd_group requires a cmd_user")
+		}
+		return attr, nil
+	}
+	cred, err := child.LookupCredential(s.CmdUser, s.CmdGroup)
+	if err != nil {
+		return attr, errors.Wrap(err, "looking up cmd_user failed")
+	}
+	attr.Credential = cred
+	return attr, nil
+}
+
 func renderTemplate(unparsed string, data interface{}) (string, error) {
 	var rendered bytes.Buffer
 	tmpl, err := template.New("").Parse(unparsed)
@@ -247,14 +499,18 @@ func renderTemplate(unparsed string, data interface{}) (string, error) {
 	return rendered.String(), nil
 }
 
-func execCommand(cmd string, logger hclog.Logger, rl *sync.RWMutex) ([]byte, error) {
-	logger.Debug("Running cmd", "command", cmd)
-	c := exec.Command("/bin/sh", "-c", cmd)
-
-	if rl != nil {
-		rl.RLock()
-		defer rl.RUnlock()
+// parseTimeout parses a command timeout, an empty string means no timeout.
+// It returns an error if the timeout isn't positive.
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
 	}
-
-	return c.CombinedOutput()
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("the timeout %q must be positive", s)
+	}
+	return d, nil
 }
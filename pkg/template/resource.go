@@ -26,15 +26,18 @@ import (
 	"context"
 	"fmt"
 	"github.com/hashicorp/go-hclog"
-	"math/rand"
+	"io"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/HeavyHorst/memkv"
 	berr "github.com/HeavyHorst/remco/pkg/backends/error"
+	"github.com/HeavyHorst/remco/pkg/child"
 	"github.com/HeavyHorst/remco/pkg/log"
 	"github.com/armon/go-metrics"
 	"github.com/pkg/errors"
@@ -42,15 +45,63 @@ import (
 
 // Resource is the representation of a parsed template resource.
 type Resource struct {
+	name     string
 	backends []Backend
 	funcMap  map[string]interface{}
 	store    *memkv.Store
 	sources  []*Renderer
 	logger   hclog.Logger
 
-	exec      Executor
-	startCmd  string
-	reloadCmd string
+	exec         Executor
+	execEnv      map[string]string
+	clearEnv     bool
+	startCmd     string
+	reloadCmd    string
+	keyCollision KeyCollisionPolicy
+	waitMin      time.Duration
+	waitMax      time.Duration
+
+	// dryRun writes a diff of all out of sync templates to diffOutput instead of writing them.
+	dryRun     bool
+	diffOutput io.Writer
+
+	// failurePolicy decides what happens if the resource fails too often within failureWindow.
+	failurePolicy FailurePolicy
+	failureWindow time.Duration
+
+	// rollbackOnReloadFailure restores the previous dst files if a reload command fails.
+	rollbackOnReloadFailure bool
+	// installed holds the templates whose dst files were replaced by the last render.
+	installed []*Renderer
+	// lastChange describes the backends and keys of the last render, it is passed to the commands.
+	lastChange change
+
+	// cache saves the backend data on disk, it is nil if the cache is disabled.
+	cache *backendCache
+	// liveChan receives the backends that were connected after the resource started with their cached data.
+	liveChan chan liveBackend
+	// cachedBackends is the number of backends that serve cached data.
+	cachedBackends int32
+	// spawned is 1 while Monitor runs and the child process was spawned, it is accessed atomically.
+	spawned int32
+	// paused is 1 while the changes of the backends are not rendered, it is accessed atomically.
+	paused int32
+	// renderChan requests a render with the data of all backends, resumeChan renders the changes
+	// that were collected while the resource was paused.
+	renderChan chan struct{}
+	resumeChan chan struct{}
+	// pingChan is served by the loop of Monitor, see Ping.
+	pingChan chan struct{}
+	// commands is the number of command phases the loop of Monitor is in, it is accessed atomically.
+	// The loop doesn't answer pings while the check and reload commands run, see Ping.
+	commands int32
+	// monitorDone is closed when the loop of Monitor stops answering pings, it is nil while the loop doesn't run.
+	// It is guarded by statusMutex.
+	monitorDone chan struct{}
+
+	status      Status
+	statusMutex sync.RWMutex
+
 	// SignalChan is a channel to send os.Signal's to all child processes.
 	SignalChan chan os.Signal
 
@@ -70,6 +121,27 @@ type ResourceConfig struct {
 	StartCmd  string
 	ReloadCmd string
 
+	// KeyCollision decides which value is used if multiple backends hold the same key.
+	KeyCollision KeyCollisionPolicy
+
+	// Wait configures the quiescence timers.
+	// Changes are merged until no change has been seen for Wait.Min or until Wait.Max is reached.
+	Wait WaitConfig
+
+	// DryRun prints a diff between the rendered templates and the current dst files to stdout
+	// instead of replacing them. No commands are executed and no child process is started.
+	DryRun bool
+
+	// RollbackOnReloadFailure restores the previous dst files and runs the reload commands again
+	// if a template or resource reload command fails.
+	RollbackOnReloadFailure bool
+
+	// Cache configures the on-disk cache of the backend data.
+	Cache CacheConfig
+
+	// FailurePolicy decides what happens if the resource fails too often.
+	FailurePolicy FailurePolicy
+
 	// Template is the configuration for all template options.
 	// You can configure as much template-destination pairs as you like.
 	Template []*Renderer
@@ -86,9 +158,106 @@ type ResourceConfig struct {
 // ErrEmptySrc is returned if an emty src template is passed to NewResource
 var ErrEmptySrc = fmt.Errorf("empty src template")
 
+// KeyCollisionPolicy decides which value is used if multiple backends of a resource hold the same key.
+type KeyCollisionPolicy string
+
+const (
+	// LastWins uses the value of the last backend. This is the default.
+	LastWins KeyCollisionPolicy = "last-wins"
+	// FirstWins uses the value of the first backend.
+	FirstWins KeyCollisionPolicy = "first-wins"
+	// Priority uses the value of the backend with the highest priority.
+	Priority KeyCollisionPolicy = "priority"
+	// FailOnCollision fails the rendering of the resource.
+	FailOnCollision KeyCollisionPolicy = "fail"
+)
+
+// Validate returns an error if p is not a known policy.
+func (p KeyCollisionPolicy) Validate() error {
+	switch p {
+	case "", LastWins, FirstWins, Priority, FailOnCollision:
+		return nil
+	}
+	return fmt.Errorf("unknown key collision policy %q - valid policies are %q, %q, %q and %q", string(p), LastWins, FirstWins, Priority, FailOnCollision)
+}
+
+// WaitConfig is the configuration of the quiescence timers of a resource.
+// The durations are strings like "2s" or "500ms".
+type WaitConfig struct {
+	// Min is the time to wait for further changes before the templates are rendered.
+	Min string `json:"min"`
+
+	// Max is the maximum time to wait before the templates are rendered, even if the changes don't stop.
+	// Defaults to 4 * Min.
+	Max string `json:"max"`
+}
+
+// durations returns the parsed min and max durations.
+func (w WaitConfig) durations() (time.Duration, time.Duration, error) {
+	var minWait, maxWait time.Duration
+	var err error
+	if w.Min != "" {
+		minWait, err = time.ParseDuration(w.Min)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "parsing wait.min failed")
+		}
+	}
+	if w.Max != "" {
+		maxWait, err = time.ParseDuration(w.Max)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "parsing wait.max failed")
+		}
+	} else {
+		maxWait = 4 * minWait
+	}
+	if maxWait < minWait {
+		return 0, 0, fmt.Errorf("wait.max (%s) is smaller than wait.min (%s)", maxWait, minWait)
+	}
+	return minWait, maxWait, nil
+}
+
+// Validate checks the wait configuration.
+func (w WaitConfig) Validate() []ConfigError {
+	if _, _, err := w.durations(); err != nil {
+		return []ConfigError{{Key: "wait", Err: err}}
+	}
+	return nil
+}
+
 // NewResourceFromResourceConfig creates a new resource from the given ResourceConfig.
 func NewResourceFromResourceConfig(ctx context.Context, reapLock *sync.RWMutex, r ResourceConfig) (*Resource, error) {
-	backendList, err := connectAllBackends(ctx, r.Connectors)
+	if err := r.KeyCollision.Validate(); err != nil {
+		return nil, err
+	}
+	waitMin, waitMax, err := r.Wait.durations()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.FailurePolicy.OnFailure.Validate(); err != nil {
+		return nil, err
+	}
+	failureWindow, err := r.FailurePolicy.window()
+	if err != nil {
+		return nil, err
+	}
+
+	logger := log.WithFields("resource", r.Name)
+	execCommand := r.Exec.Command
+	if r.DryRun {
+		execCommand = ""
+	}
+	exec, err := newExecutorFromConfig(r.Name, execCommand, r.Exec, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := newBackendCache(r.Cache, r.Name)
+	if err != nil {
+		return nil, err
+	}
+	liveChan := make(chan liveBackend, len(r.Connectors))
+
+	backendList, err := connectAllBackends(ctx, r.Connectors, cache, liveChan)
 	if err != nil {
 		return nil, errors.Wrap(err, "connectAllBackends failed")
 	}
@@ -97,15 +266,32 @@ func NewResourceFromResourceConfig(ctx context.Context, reapLock *sync.RWMutex,
 		p.ReapLock = reapLock
 	}
 
-	logger := log.WithFields("resource", r.Name)
-	exec := NewExecutor(r.Exec.Command, r.Exec.ReloadSignal, r.Exec.KillSignal, r.Exec.KillTimeout, r.Exec.Splay, logger)
 	res, err := NewResource(backendList, r.Template, r.Name, exec, r.StartCmd, r.ReloadCmd)
 	if err != nil {
 		for _, v := range backendList {
 			v.Close()
 		}
+		return nil, err
 	}
-	return res, err
+	res.keyCollision = r.KeyCollision
+	res.execEnv, res.clearEnv = r.Exec.Env, r.Exec.ClearEnv
+	res.waitMin, res.waitMax = waitMin, waitMax
+	res.failurePolicy, res.failureWindow = r.FailurePolicy, failureWindow
+	res.rollbackOnReloadFailure = r.RollbackOnReloadFailure
+	res.cache = cache
+	res.liveChan = liveChan
+	for _, b := range res.backends {
+		if b.cached {
+			res.cachedBackends++
+		}
+	}
+	res.setDegradedGauge()
+	if r.DryRun {
+		res.dryRun = true
+		res.startCmd = ""
+		res.reloadCmd = ""
+	}
+	return res, nil
 }
 
 // NewResource creates a Resource.
@@ -124,8 +310,10 @@ func NewResource(backends []Backend, sources []*Renderer, name string, exec Exec
 	}
 
 	tr := &Resource{
+		name:       name,
 		backends:   backends,
 		store:      memkv.New(),
+		diffOutput: os.Stdout,
 		funcMap:    newFuncMap(),
 		sources:    sources,
 		logger:     logger,
@@ -133,6 +321,12 @@ func NewResource(backends []Backend, sources []*Renderer, name string, exec Exec
 		exec:       exec,
 		startCmd:   startCmd,
 		reloadCmd:  reloadCmd,
+		renderChan: make(chan struct{}, 1),
+		resumeChan: make(chan struct{}, 1),
+		pingChan:   make(chan struct{}),
+		status:     Status{State: StateStarting},
+
+		failureWindow: DefaultFailureWindow,
 	}
 
 	// initialize the individual backend memkv Stores
@@ -144,6 +338,11 @@ func NewResource(backends []Backend, sources []*Renderer, name string, exec Exec
 			logger.Warn("interval needs to be > 0: setting interval to 60")
 			tr.backends[i].Interval = 60
 		}
+		bs := BackendStatus{Name: tr.backends[i].Name, Cached: tr.backends[i].cached}
+		if bs.Cached {
+			bs.FailingSince = time.Now()
+		}
+		tr.status.Backends = append(tr.status.Backends, bs)
 	}
 
 	addFuncs(tr.funcMap, tr.store.FuncMap)
@@ -157,6 +356,25 @@ func NewResource(backends []Backend, sources []*Renderer, name string, exec Exec
 	return tr, nil
 }
 
+// Degraded reports whether the resource renders the cached data of a backend that couldn't be reached.
+func (t *Resource) Degraded() bool {
+	return atomic.LoadInt32(&t.cachedBackends) > 0
+}
+
+// Ready reports whether the resource was rendered and its child process is ready.
+// Resources without a child process are ready once Monitor has rendered the templates.
+func (t *Resource) Ready() bool {
+	return atomic.LoadInt32(&t.spawned) == 1 && t.exec.Ready()
+}
+
+func (t *Resource) setDegradedGauge() {
+	var v float32
+	if t.Degraded() {
+		v = 1
+	}
+	metrics.SetGaugeWithLabels([]string{"resources", "degraded"}, v, []metrics.Label{{Name: "name", Value: t.name}})
+}
+
 // Close closes the connection to all underlying backends.
 func (t *Resource) Close() {
 	for _, v := range t.backends {
@@ -169,9 +387,10 @@ func (t *Resource) Close() {
 
 // setVars reads all KV-Pairs for the backend
 // and writes these pairs to the individual (per backend) memkv store.
+// The keys are stored below the mount path of the backend.
 // After that, the instance wide memkv store gets purged and is recreated with all individual
 // memkv KV-Pairs.
-// Key collisions are logged.
+// Key collisions are resolved according to the key collision policy.
 // It returns an error if any.
 func (t *Resource) setVars(storeClient Backend) error {
 	var err error
@@ -186,18 +405,48 @@ func (t *Resource) setVars(storeClient Backend) error {
 		return errors.Wrap(err, "getValues failed")
 	}
 
+	if t.cache != nil && !storeClient.cached {
+		if err := t.cache.save(storeClient, result); err != nil {
+			t.logger.Warn("failed to cache the backend data", "backend", storeClient.Name, "error", err)
+		}
+	}
+
 	storeClient.store.Purge()
 
 	for key, value := range result {
-		storeClient.store.Set(path.Join("/", strings.TrimPrefix(key, storeClient.Prefix)), value)
+		storeClient.store.Set(path.Join("/", storeClient.Mount, strings.TrimPrefix(key, storeClient.Prefix)), value)
+	}
+
+	return t.mergeStores()
+}
+
+// mergeStores recreates the instance wide memkv store from the individual backend stores.
+func (t *Resource) mergeStores() error {
+	backends := t.backends
+	if t.keyCollision == Priority {
+		// the backend with the highest priority is merged last
+		backends = make([]Backend, len(t.backends))
+		copy(backends, t.backends)
+		sort.SliceStable(backends, func(i, j int) bool {
+			return backends[i].Priority < backends[j].Priority
+		})
 	}
 
-	//merge all stores
 	t.store.Purge()
-	for _, v := range t.backends {
+	for _, v := range backends {
 		for _, kv := range v.store.GetAllKVs() {
 			if t.store.Exists(kv.Key) {
-				t.logger.Warn("key collision", "key", kv.Key)
+				switch t.keyCollision {
+				case FirstWins:
+					t.logger.Warn("key collision", "key", kv.Key, "backend", v.Name)
+					continue
+				case Priority:
+					t.logger.Debug("key collision", "key", kv.Key, "backend", v.Name)
+				case FailOnCollision:
+					return fmt.Errorf("key collision: %s (backend %s)", kv.Key, v.Name)
+				default:
+					t.logger.Warn("key collision", "key", kv.Key, "backend", v.Name)
+				}
 			}
 			t.store.Set(kv.Key, kv.Value)
 		}
@@ -206,24 +455,107 @@ func (t *Resource) setVars(storeClient Backend) error {
 	return nil
 }
 
+// createStageFileAndSync renders all templates of the resource as a single transaction.
+// All templates are staged and checked first, only then the dest files are replaced.
+// If a dest file can't be replaced, the already replaced files are rolled back.
+// The reload commands run after all files are replaced.
+// It returns a boolean indicating if any file has changed and an error if any.
 func (t *Resource) createStageFileAndSync(runCommands bool) (bool, error) {
-	var changed bool
+	t.installed = nil
+	defer func() {
+		for _, s := range t.sources {
+			s.removeStageFile()
+		}
+	}()
+
 	for _, s := range t.sources {
-		err := s.createStageFile(t.funcMap)
+		err := s.createStageFile(t.funcMap, t.dryRun)
 		if err != nil {
 			metrics.IncrCounter([]string{"files", "stage_errors_total"}, 1)
-			return changed, errors.Wrap(err, "create stage file failed")
+			return false, errors.Wrap(err, "create stage file failed")
 		}
 		metrics.IncrCounter([]string{"files", "staged_total"}, 1)
-		c, err := s.syncFiles(runCommands)
-		changed = changed || c
+	}
+
+	if t.dryRun {
+		// nothing is written, so nothing needs to be reloaded
+		for _, s := range t.sources {
+			if _, err := s.diffFiles(t.diffOutput); err != nil {
+				return false, errors.Wrap(err, "diff files failed")
+			}
+		}
+		return false, nil
+	}
+
+	if runCommands {
+		// the check and reload commands may run for a long time
+		defer t.startCommands()()
+	}
+
+	var outOfSync []*Renderer
+	for _, s := range t.sources {
+		c, err := s.prepare(runCommands, t.lastChange.env(t.name, nil))
 		if err != nil {
 			metrics.IncrCounter([]string{"files", "sync_errors_total"}, 1)
-			return changed, errors.Wrap(err, "sync files failed")
+			return false, errors.Wrap(err, "sync files failed")
+		}
+		if c {
+			outOfSync = append(outOfSync, s)
+		}
+	}
+
+	for i, s := range outOfSync {
+		if err := s.install(); err != nil {
+			metrics.IncrCounter([]string{"files", "sync_errors_total"}, 1)
+			t.rollback(outOfSync[:i], 0)
+			return false, errors.Wrap(err, "sync files failed")
+		}
+	}
+	t.installed = outOfSync
+
+	env := t.lastChange.env(t.name, outOfSync)
+	for i, s := range outOfSync {
+		if runCommands {
+			if err := s.reload(s.Dst, env); err != nil {
+				metrics.IncrCounter([]string{"files", "sync_errors_total"}, 1)
+				if t.rollbackOnReloadFailure {
+					metrics.IncrCounter([]string{"files", "reload_failures_total"}, 1)
+					t.logger.Error("reload command failed, rolling back", "config", s.Dst, "error", err)
+					t.rollback(outOfSync, i+1)
+					t.installed = nil
+					return false, errors.Wrap(err, "reload command failed, the previous config has been restored")
+				}
+				return true, errors.Wrap(err, "reload command failed")
+			}
+		}
+		s.logger.With(
+			"config", s.Dst,
+		).Info("target config has been updated")
+	}
+	metrics.IncrCounter([]string{"files", "synced_total"}, float32(len(t.sources)))
+
+	return len(outOfSync) > 0, nil
+}
+
+// rollback restores the previous dst files of the installed templates in reverse order.
+// The reload commands of the first reloaded templates run again against the restored files.
+// Errors are logged and recorded in the metrics.
+func (t *Resource) rollback(installed []*Renderer, reloaded int) {
+	for i := len(installed) - 1; i >= 0; i-- {
+		s := installed[i]
+		if err := s.rollback(); err != nil {
+			metrics.IncrCounter([]string{"files", "rollback_errors_total"}, 1)
+			t.logger.Error("rollback failed", "config", s.Dst, "error", err)
+			continue
+		}
+		metrics.IncrCounter([]string{"files", "rollbacks_total"}, 1)
+	}
+	env := t.lastChange.env(t.name, installed)
+	for _, s := range installed[:reloaded] {
+		if err := s.reload(s.Dst, env); err != nil {
+			t.logger.Error("reload of the restored config failed", "config", s.Dst, "error", err)
 		}
-		metrics.IncrCounter([]string{"files", "synced_total"}, 1)
 	}
-	return changed, nil
 }
 
 // Process is a convenience function that wraps calls to the three main tasks
@@ -234,9 +566,14 @@ func (t *Resource) createStageFileAndSync(runCommands bool) (bool, error) {
 func (t *Resource) process(storeClients []Backend, runCommands bool) (bool, error) {
 	var changed bool
 	var err error
-	for _, storeClient := range storeClients {
+	previous := t.store.GetAllKVs()
+	names := make([]string, len(storeClients))
+	for i, storeClient := range storeClients {
+		names[i] = storeClient.Name
 		labels := []metrics.Label{{Name: "name", Value: storeClient.Name}}
-		if err = t.setVars(storeClient); err != nil {
+		err = t.setVars(storeClient)
+		t.setBackendStatus(storeClient.Name, storeClient.cached, err)
+		if err != nil {
 			metrics.IncrCounterWithLabels([]string{"backends", "sync_errors_total"}, 1, labels)
 			return changed, berr.BackendError{
 				Message: errors.Wrap(err, "setVars failed").Error(),
@@ -245,17 +582,146 @@ func (t *Resource) process(storeClients []Backend, runCommands bool) (bool, erro
 		}
 		metrics.IncrCounterWithLabels([]string{"backends", "synced_total"}, 1, labels)
 	}
+	t.lastChange = change{backends: names, keys: changedKeys(previous, t.store.GetAllKVs())}
 	if changed, err = t.createStageFileAndSync(runCommands); err != nil {
 		return changed, errors.Wrap(err, "createStageFileAndSync failed")
 	}
+	t.setRendered()
 	return changed, nil
 }
 
+// childEnv returns the environment of the child process with the current backend data.
+func (t *Resource) childEnv() ([]string, error) {
+	return renderEnv(t.execEnv, t.clearEnv, t.store, t.funcMap)
+}
+
+// updateEnv restarts the child process if one of the values of its environment changed.
+// It reports whether the child process was restarted.
+func (t *Resource) updateEnv() bool {
+	if len(t.execEnv) == 0 {
+		return false
+	}
+	env, err := t.childEnv()
+	if err != nil {
+		t.logger.Error("failed to render the environment of the child", "error", err)
+		return false
+	}
+	if envEqual(env, t.exec.env) {
+		return false
+	}
+	t.logger.Info("the environment of the child changed, restarting the child")
+	if err := t.exec.SetEnv(env); err != nil {
+		t.logger.Error("failed to restart the child", "error", err)
+		return false
+	}
+	t.exec.env = env
+	return true
+}
+
+// processChanges processes the templates with the data of the given backends.
+// The child process is reloaded and the resource reload command is executed if a template has changed.
+func (t *Resource) processChanges(storeClients []Backend) {
+	changed, err := t.process(storeClients, true)
+	if err != nil {
+		t.setError(err)
+		switch err := err.(type) {
+		case berr.BackendError:
+			t.logger.With("backend", err.Backend, "error", err).Error("backend error")
+		default:
+			t.logger.Error("default handler", "error", err)
+		}
+		return
+	}
+
+	// the restart or the reload of the child and the reload command may run for a long time
+	defer t.startCommands()()
+
+	// a restart with the new environment replaces the reload
+	restarted := t.updateEnv()
+	if changed {
+		if !restarted {
+			if err := t.exec.Reload(); err != nil {
+				t.logger.Error("failed to reload", "error", err)
+			}
+		}
+
+		if t.reloadCmd != "" {
+			output, err := execCommand(ShellCommand(t.reloadCmd), t.lastChange.env(t.name, t.installed), child.Attr{}, 0, t.logger, nil)
+			if err != nil {
+				t.logger.Error("failed to execute the resource reload cmd", "output", string(output), "error", err)
+				if t.rollbackOnReloadFailure {
+					t.rollbackResource()
+				}
+			}
+		}
+	}
+}
+
+// rollbackResource restores the dst files of the last render after the resource reload command failed.
+// The child process is reloaded and all reload commands run again against the restored files.
+func (t *Resource) rollbackResource() {
+	if len(t.installed) == 0 {
+		return
+	}
+	metrics.IncrCounter([]string{"files", "reload_failures_total"}, 1)
+	t.logger.Error("resource reload cmd failed, rolling back")
+	env := t.lastChange.env(t.name, t.installed)
+	t.rollback(t.installed, len(t.installed))
+	t.installed = nil
+
+	if err := t.exec.Reload(); err != nil {
+		t.logger.Error("failed to reload", "error", err)
+	}
+	output, err := execCommand(ShellCommand(t.reloadCmd), env, child.Attr{}, 0, t.logger, nil)
+	if err != nil {
+		t.logger.Error("failed to execute the resource reload cmd with the restored config", "output", string(output), "error", err)
+	}
+}
+
+// appendBackend appends b to backends if it isn't already part of it.
+func appendBackend(backends []Backend, b Backend) []Backend {
+	for _, v := range backends {
+		if v.store == b.store {
+			return backends
+		}
+	}
+	return append(backends, b)
+}
+
+// switchToLive replaces the cached backend with the connected backend.
+// It returns the connected backend.
+func (t *Resource) switchToLive(lb liveBackend) Backend {
+	cached := t.backends[lb.index]
+	lb.backend.store = cached.store
+	t.backends[lb.index] = lb.backend
+	cached.Close()
+	atomic.AddInt32(&t.cachedBackends, -1)
+	t.setDegradedGauge()
+	t.setBackendStatus(lb.backend.Name, false, nil)
+	t.logger.With(
+		"backend", lb.backend.Name,
+	).Info("backend connected, switching from the cached to the live data")
+	return lb.backend
+}
+
 // Monitor will start to monitor all given Backends for changes.
 // It accepts a ctx.Context for cancelation.
 // It will process all given templates on changes.
 func (t *Resource) Monitor(ctx context.Context) {
 	t.Failed = false
+	var childExited bool
+	t.setState(StateStarting)
+	defer func() {
+		if t.Failed {
+			if !childExited {
+				// the exit of the child process was already recorded by the executor
+				t.exec.failures.add(time.Now())
+			}
+			t.setState(StateFailed)
+		} else {
+			t.setState(StateStopped)
+		}
+	}()
 	wg := &sync.WaitGroup{}
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -266,17 +732,23 @@ func (t *Resource) Monitor(ctx context.Context) {
 	errChan := make(chan berr.BackendError, 10)
 
 	// try to process the template resource with all given backends
-	// we wait a random amount of time (between 0 - 30 seconds)
+	// we wait an exponential backoff with jitter (up to 30 seconds)
 	// to prevent ddossing our backends and try again (with all backends - no stale data)
 	retryChan := make(chan struct{}, 1)
 	retryChan <- struct{}{}
+	var attempt int
 retryloop:
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-retryChan:
-			if _, err := t.process(t.backends, t.startCmd == ""); err != nil {
+			_, err := t.process(t.backends, t.startCmd == "")
+			if err == nil {
+				t.exec.env, err = t.childEnv()
+			}
+			if err != nil {
+				t.setError(err)
 				switch err := err.(type) {
 				case berr.BackendError:
 					t.logger.With(
@@ -292,14 +764,13 @@ retryloop:
 					cancel()
 					return
 				} else {
+					delay := jitter(backoff(restartBackoffMin, restartBackoffMax, attempt))
+					attempt++
 					go func() {
-						rn := rand.Int63n(30)
-						t.logger.Error(fmt.Sprintf("not all templates could be rendered, trying again after %d seconds", rn))
-						time.Sleep(time.Duration(rn) * time.Second)
+						t.logger.Error(fmt.Sprintf("not all templates could be rendered, trying again after %s", delay))
 						select {
 						case <-ctx.Done():
-							return
-						default:
+						case <-time.After(delay):
 							retryChan <- struct{}{}
 						}
 					}()
@@ -311,8 +782,9 @@ retryloop:
 	}
 
 	if t.startCmd != "" {
-		output, err := execCommand(t.startCmd, t.logger, nil)
+		output, err := execCommand(ShellCommand(t.startCmd), t.lastChange.env(t.name, t.installed), child.Attr{}, 0, t.logger, nil)
 		if err != nil {
+			t.setError(errors.Wrap(err, "start cmd failed"))
 			t.logger.Error(fmt.Sprintf("failed to execute the start cmd - %q", string(output)))
 			t.Failed = true
 			cancel()
@@ -323,11 +795,20 @@ retryloop:
 
 	err := t.exec.SpawnChild()
 	if err != nil {
+		t.setError(errors.Wrap(err, "failed to spawn child"))
 		t.logger.Error("failed to spawn child", "error", err)
 		t.Failed = true
 		cancel()
 	} else {
 		defer t.exec.StopChild()
+		atomic.StoreInt32(&t.spawned, 1)
+		defer atomic.StoreInt32(&t.spawned, 0)
+		t.setState(StateRunning)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.exec.WatchHealth(ctx)
+		}()
 	}
 
 	done := make(chan struct{})
@@ -339,13 +820,15 @@ retryloop:
 		defer wg.Done()
 		failed := t.exec.Wait(ctx)
 		if failed {
+			t.setError(fmt.Errorf("the child process exited unexpectedly"))
+			childExited = true
 			t.Failed = true
 			cancel()
 		}
 	}()
 
 	// start the watch and interval processors so that we get notfied on changes
-	for _, sc := range t.backends {
+	startProcessors := func(sc Backend) {
 		if sc.Watch {
 			wg.Add(1)
 			go func(s Backend) {
@@ -362,6 +845,21 @@ retryloop:
 			}(sc)
 		}
 	}
+	for _, sc := range t.backends {
+		if sc.cached {
+			// the data of a cached backend never changes
+			continue
+		}
+		startProcessors(sc)
+	}
+	if t.Degraded() {
+		// keep running until the backends are connected
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-ctx.Done()
+		}()
+	}
 
 	go func() {
 		// If there is no goroutine left - quit
@@ -369,37 +867,72 @@ retryloop:
 		close(done)
 	}()
 
+	// the backends with changes that are not processed yet and the quiescence timers
+	var pending []Backend
+	var minTimer, maxTimer <-chan time.Time
+	flush := func() {
+		minTimer, maxTimer = nil, nil
+		if t.Paused() {
+			// the changes are rendered on resume
+			return
+		}
+		t.processChanges(pending)
+		pending = nil
+	}
+
+	t.startHeartbeat()
+	defer t.stopHeartbeat()
 	for {
 		select {
+		case <-t.pingChan:
 		case storeClient := <-processChan:
-			changed, err := t.process([]Backend{storeClient}, true)
-			if err != nil {
-				switch err.(type) {
-				case berr.BackendError:
-					t.logger.With("backend", storeClient.Name, "error", err).Error("backend error")
-				default:
-					t.logger.Error("default handler", "error", err)
-				}
-			} else if changed {
-				if err := t.exec.Reload(); err != nil {
-					t.logger.Error("failed to reload", "error", err)
-				}
-
-				if t.reloadCmd != "" {
-					output, err := execCommand(t.reloadCmd, t.logger, nil)
-					if err != nil {
-						t.logger.Error("failed to execute the resource reload cmd", "output", string(output), "error", err)
-					}
-				}
+			if t.Paused() {
+				pending = appendBackend(pending, storeClient)
+				t.logger.Debug("resource paused, not rendering the changes", "backend", storeClient.Name)
+				continue
+			}
+			if t.waitMin <= 0 {
+				t.processChanges([]Backend{storeClient})
+				continue
+			}
+			pending = appendBackend(pending, storeClient)
+			minTimer = time.After(t.waitMin)
+			if maxTimer == nil {
+				maxTimer = time.After(t.waitMax)
+			}
+			t.logger.Debug("waiting for further changes", "backend", storeClient.Name)
+		case lb := <-t.liveChan:
+			b := t.switchToLive(lb)
+			startProcessors(b)
+			if t.Paused() {
+				pending = appendBackend(pending, b)
+				continue
+			}
+			t.processChanges([]Backend{b})
+		case <-t.renderChan:
+			t.logger.Info("rendering the resource on request")
+			t.processChanges(t.backends)
+			pending = nil
+			minTimer, maxTimer = nil, nil
+		case <-t.resumeChan:
+			if len(pending) > 0 {
+				flush()
 			}
+		case <-minTimer:
+			flush()
+		case <-maxTimer:
+			flush()
 		case s := <-t.SignalChan:
 			err := t.exec.SignalChild(s)
 			if err != nil {
 				t.logger.Error("failed to signal child", "error", err)
 			}
 		case err := <-errChan:
+			t.setBackendStatus(err.Backend, false, errors.New(err.Message))
 			t.logger.With("backend", err.Backend).Error("error", "message", err.Message)
 		case <-ctx.Done():
+			// stopping the child may take the kill_timeout
+			t.stopHeartbeat()
 			go func() {
 				for range processChan {
 				}
@@ -9,13 +9,21 @@
 package template
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/HeavyHorst/easykv"
 	"github.com/HeavyHorst/easykv/mock"
+	"github.com/HeavyHorst/remco/pkg/template/fileutil"
 
 	. "gopkg.in/check.v1"
 )
@@ -63,8 +71,8 @@ func (s *ResourceSuite) SetUpSuite(t *C) {
 	s.renderer = &Renderer{
 		Src:       s.templateFile,
 		Dst:       "/tmp/remco-basic-test.conf",
-		CheckCmd:  "exit 0",
-		ReloadCmd: "exit 0",
+		CheckCmd:  ShellCommand("exit 0"),
+		ReloadCmd: ShellCommand("exit 0"),
 	}
 
 	exec := NewExecutor("", "", "", 0, 0, nil)
@@ -143,3 +151,553 @@ func (s *ResourceSuite) TestMonitorWithBackendError(t *C) {
 	t.Check(s.resource.Failed, Equals, false)
 	s.resource.backends[0].ReadWatcher.(*mock.Client).Err = nil
 }
+
+func newCollisionResource(t *C, policy KeyCollisionPolicy, mount string) *Resource {
+	first := Backend{Name: "first", Keys: []string{"/"}, Onetime: true, Priority: 2}
+	first.ReadWatcher, _ = mock.New(nil, map[string]string{"/key": "first"})
+	second := Backend{Name: "second", Keys: []string{"/"}, Onetime: true, Priority: 1, Mount: mount}
+	second.ReadWatcher, _ = mock.New(nil, map[string]string{"/key": "second"})
+
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource([]Backend{first, second}, nil, "collision", exec, "", "")
+	t.Assert(err, IsNil)
+	res.keyCollision = policy
+	return res
+}
+
+func (s *ResourceSuite) TestKeyCollision(t *C) {
+	for policy, expected := range map[KeyCollisionPolicy]string{
+		"":        "second",
+		LastWins:  "second",
+		FirstWins: "first",
+		Priority:  "first",
+	} {
+		res := newCollisionResource(t, policy, "")
+		for _, b := range res.backends {
+			t.Assert(res.setVars(b), IsNil)
+		}
+		v, err := res.store.GetValue("/key")
+		t.Check(err, IsNil)
+		t.Check(v, Equals, expected, Commentf("policy %q", policy))
+	}
+
+	res := newCollisionResource(t, FailOnCollision, "")
+	t.Check(res.setVars(res.backends[0]), IsNil)
+	t.Check(res.setVars(res.backends[1]), ErrorMatches, "key collision: /key .*")
+}
+
+func (s *ResourceSuite) TestMount(t *C) {
+	res := newCollisionResource(t, FailOnCollision, "/mnt")
+	for _, b := range res.backends {
+		t.Assert(res.setVars(b), IsNil)
+	}
+	v, err := res.store.GetValue("/key")
+	t.Check(err, IsNil)
+	t.Check(v, Equals, "first")
+	v, err = res.store.GetValue("/mnt/key")
+	t.Check(err, IsNil)
+	t.Check(v, Equals, "second")
+}
+
+func (s *ResourceSuite) TestKeyCollisionPolicyValidate(t *C) {
+	t.Check(Priority.Validate(), IsNil)
+	t.Check(KeyCollisionPolicy("random").Validate(), NotNil)
+}
+
+func (s *ResourceSuite) TestWaitConfig(t *C) {
+	minWait, maxWait, err := WaitConfig{}.durations()
+	t.Check(err, IsNil)
+	t.Check(minWait, Equals, time.Duration(0))
+	t.Check(maxWait, Equals, time.Duration(0))
+
+	minWait, maxWait, err = WaitConfig{Min: "2s"}.durations()
+	t.Check(err, IsNil)
+	t.Check(minWait, Equals, 2*time.Second)
+	t.Check(maxWait, Equals, 8*time.Second)
+
+	minWait, maxWait, err = WaitConfig{Min: "2s", Max: "10s"}.durations()
+	t.Check(err, IsNil)
+	t.Check(minWait, Equals, 2*time.Second)
+	t.Check(maxWait, Equals, 10*time.Second)
+
+	t.Check(WaitConfig{Min: "10s", Max: "2s"}.Validate(), HasLen, 1)
+	t.Check(WaitConfig{Min: "soon"}.Validate(), HasLen, 1)
+}
+
+// eventClient is a backend that reports a change with new data for every value sent to events.
+type eventClient struct {
+	mu     sync.Mutex
+	n      int
+	events chan struct{}
+}
+
+func (c *eventClient) GetValues(keys []string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return map[string]string{"/some/path/data": strconv.Itoa(c.n)}, nil
+}
+
+func (c *eventClient) WatchPrefix(ctx context.Context, prefix string, opts ...easykv.WatchOption) (uint64, error) {
+	select {
+	case <-c.events:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.n++
+		return uint64(c.n), nil
+	case <-ctx.Done():
+		return 0, easykv.ErrWatchCanceled
+	}
+}
+
+func (c *eventClient) Close() {}
+
+func (s *ResourceSuite) TestMonitorWait(t *C) {
+	dir := t.MkDir()
+	counter := filepath.Join(dir, "reloads")
+	client := &eventClient{events: make(chan struct{})}
+	b := Backend{Name: "events", Keys: []string{"/"}, Watch: true, ReadWatcher: client}
+	r := &Renderer{Src: s.templateFile, Dst: filepath.Join(dir, "app.conf"), ReloadCmd: ShellCommand("echo >> " + counter)}
+	res, err := NewResource([]Backend{b}, []*Renderer{r}, "wait", NewExecutor("", "", "", 0, 0, nil), "", "")
+	t.Assert(err, IsNil)
+	res.waitMin, res.waitMax = 300*time.Millisecond, 700*time.Millisecond
+
+	reloads := func() int {
+		data, _ := ioutil.ReadFile(counter)
+		return strings.Count(string(data), "\n")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		res.Monitor(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+	for res.Status().LastRender.IsZero() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	initial := reloads()
+
+	// a burst within wait.min results in a single render and reload
+	for i := 0; i < 5; i++ {
+		client.events <- struct{}{}
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(3 * res.waitMin)
+	t.Check(reloads(), Equals, initial+1)
+
+	// changes that don't stop are flushed after wait.max
+	initial = reloads()
+	start := time.Now()
+	for time.Since(start) < 2*res.waitMax {
+		client.events <- struct{}{}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Check(reloads() > initial, Equals, true, Commentf("no reload while the changes continued"))
+}
+
+func (s *ResourceSuite) TestAppendBackend(t *C) {
+	res := newCollisionResource(t, LastWins, "")
+	pending := appendBackend(nil, res.backends[0])
+	pending = appendBackend(pending, res.backends[1])
+	pending = appendBackend(pending, res.backends[0])
+	t.Check(pending, HasLen, 2)
+}
+
+// countingWriter counts the calls to Write.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func (s *ResourceSuite) TestDryRun(t *C) {
+	dir := t.MkDir()
+	dst := filepath.Join(dir, "dry-run.conf")
+	t.Assert(ioutil.WriteFile(dst, []byte("old\n"), 0644), IsNil)
+
+	renderer := &Renderer{
+		Src:       s.templateFile,
+		Dst:       dst,
+		Mode:      "0600",
+		CheckCmd:  ShellCommand("exit 1"),
+		ReloadCmd: ShellCommand("exit 1"),
+	}
+	b := Backend{Name: "mock", Keys: []string{"/"}, Onetime: true}
+	b.ReadWatcher, _ = mock.New(nil, map[string]string{"/some/path/data": "someData"})
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource([]Backend{b}, []*Renderer{renderer}, "dry-run", exec, "", "")
+	t.Assert(err, IsNil)
+	var out countingWriter
+	res.dryRun = true
+	res.diffOutput = &out
+
+	changed, err := res.process(res.backends, true)
+	t.Assert(err, IsNil)
+	t.Check(changed, Equals, false)
+
+	// the dst file is untouched
+	data, err := ioutil.ReadFile(dst)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "old\n")
+
+	// the diff is written at once, so that it doesn't interleave with the diffs of other resources
+	t.Check(out.writes, Equals, 1)
+	t.Check(out.String(), Matches, `(?s)# .*/dry-run.conf\n# filemode: -rw-r--r-- -> -rw-------\n--- .*/dry-run.conf\n\+\+\+ .*/dry-run.conf\n.*-old\n\+\[\n.*`)
+}
+
+func newTransactionResource(t *C, renderers ...*Renderer) *Resource {
+	b := Backend{Name: "mock", Keys: []string{"/"}, Onetime: true}
+	b.ReadWatcher, _ = mock.New(nil, map[string]string{"/some/path/data": "someData"})
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource([]Backend{b}, renderers, "transaction", exec, "", "")
+	t.Assert(err, IsNil)
+	return res
+}
+
+func (s *ResourceSuite) TestTransactionCheckFailed(t *C) {
+	dir := t.MkDir()
+	first := filepath.Join(dir, "first.conf")
+	second := filepath.Join(dir, "second.conf")
+	t.Assert(ioutil.WriteFile(first, []byte("old\n"), 0644), IsNil)
+
+	res := newTransactionResource(t,
+		&Renderer{Src: s.templateFile, Dst: first, CheckCmd: ShellCommand("exit 0")},
+		&Renderer{Src: s.templateFile, Dst: second, CheckCmd: ShellCommand("exit 1")},
+	)
+	changed, err := res.process(res.backends, true)
+	t.Check(err, NotNil)
+	t.Check(changed, Equals, false)
+
+	// no file is replaced and no stage file is left behind
+	data, err := ioutil.ReadFile(first)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "old\n")
+	files, err := ioutil.ReadDir(dir)
+	t.Assert(err, IsNil)
+	t.Check(files, HasLen, 1)
+}
+
+func (s *ResourceSuite) TestTransactionRollback(t *C) {
+	dir := t.MkDir()
+	first := filepath.Join(dir, "first.conf")
+	created := filepath.Join(dir, "created.conf")
+	t.Assert(ioutil.WriteFile(first, []byte("old\n"), 0600), IsNil)
+	// a non-empty directory can't be replaced by a file
+	second := filepath.Join(dir, "second.conf")
+	t.Assert(os.Mkdir(second, 0755), IsNil)
+	t.Assert(ioutil.WriteFile(filepath.Join(second, "file"), nil, 0644), IsNil)
+
+	res := newTransactionResource(t,
+		&Renderer{Src: s.templateFile, Dst: first, Mode: "0644", ReloadCmd: ShellCommand("exit 1")},
+		&Renderer{Src: s.templateFile, Dst: created, ReloadCmd: ShellCommand("exit 1")},
+		&Renderer{Src: s.templateFile, Dst: second, ReloadCmd: ShellCommand("exit 1")},
+	)
+	changed, err := res.process(res.backends, true)
+	t.Check(err, ErrorMatches, ".*sync files failed.*")
+	t.Check(changed, Equals, false)
+
+	data, err := ioutil.ReadFile(first)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "old\n")
+	fi, err := os.Stat(first)
+	t.Assert(err, IsNil)
+	t.Check(fi.Mode().Perm(), Equals, os.FileMode(0600))
+	t.Check(fileutil.IsFileExist(created), Equals, false)
+}
+
+func (s *ResourceSuite) TestRollbackOnReloadFailure(t *C) {
+	dir := t.MkDir()
+	dst := filepath.Join(dir, "app.conf")
+	reloadLog := filepath.Join(dir, "reload.log")
+	t.Assert(ioutil.WriteFile(dst, []byte("old\n"), 0644), IsNil)
+
+	// the reload command only accepts the old config
+	res := newTransactionResource(t, &Renderer{
+		Src:       s.templateFile,
+		Dst:       dst,
+		ReloadCmd: ShellCommand(fmt.Sprintf("head -n 1 {{.dst}} >> %s && grep -q old {{.dst}}", reloadLog)),
+	})
+	res.rollbackOnReloadFailure = true
+
+	changed, err := res.process(res.backends, true)
+	t.Check(err, ErrorMatches, ".*the previous config has been restored.*")
+	t.Check(changed, Equals, false)
+
+	data, err := ioutil.ReadFile(dst)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "old\n")
+	// the reload command ran again against the restored config
+	data, err = ioutil.ReadFile(reloadLog)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "[\nold\n")
+}
+
+func (s *ResourceSuite) TestRollbackOnResourceReloadFailure(t *C) {
+	dir := t.MkDir()
+	dst := filepath.Join(dir, "app.conf")
+	t.Assert(ioutil.WriteFile(dst, []byte("old\n"), 0644), IsNil)
+
+	res := newTransactionResource(t, &Renderer{Src: s.templateFile, Dst: dst})
+	res.reloadCmd = "exit 1"
+	res.rollbackOnReloadFailure = true
+	t.Assert(res.exec.SpawnChild(), IsNil)
+	defer res.exec.StopChild()
+	res.processChanges(res.backends)
+
+	data, err := ioutil.ReadFile(dst)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "old\n")
+	t.Check(res.installed, HasLen, 0)
+}
+
+func (s *ResourceSuite) TestExecEnv(t *C) {
+	out := filepath.Join(t.MkDir(), "env")
+	b := Backend{Name: "mock", Keys: []string{"/"}, Onetime: true}
+	client, _ := mock.New(nil, map[string]string{"/db/host": "db1"})
+	b.ReadWatcher = client
+	exec, err := newExecutorFromConfig("env", fmt.Sprintf(`bash -c 'echo "$DB_HOST $DB_URL $HOME" > %s; exec sleep 5'`, out), ExecConfig{}, nil)
+	t.Assert(err, IsNil)
+	res, err := NewResource([]Backend{b}, nil, "env", exec, "", "")
+	t.Assert(err, IsNil)
+	res.execEnv = map[string]string{
+		"DB_HOST": "/db/host",
+		"DB_URL":  `postgres://{{ getv("/db/host") }}/app`,
+	}
+	res.clearEnv = true
+
+	_, err = res.process(res.backends, true)
+	t.Assert(err, IsNil)
+	res.exec.env, err = res.childEnv()
+	t.Assert(err, IsNil)
+	t.Check(res.exec.env, DeepEquals, []string{"DB_HOST=db1", "DB_URL=postgres://db1/app"})
+	t.Assert(res.exec.SpawnChild(), IsNil)
+	defer res.exec.StopChild()
+
+	waitForContent := func(expected string) {
+		for i := 0; i < 100; i++ {
+			if data, _ := ioutil.ReadFile(out); string(data) == expected {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Errorf("%s doesn't contain %q", out, expected)
+	}
+	waitForContent("db1 postgres://db1/app \n")
+
+	// the child is restarted with the new value
+	client.Data = map[string]string{"/db/host": "db2"}
+	res.processChanges(res.backends)
+	waitForContent("db2 postgres://db2/app \n")
+
+	t.Check(validateEnv(map[string]string{"A=B": "/key", "C": "{{ getv( }}", "D": "/key"}), HasLen, 2)
+}
+
+func (s *ResourceSuite) TestCommandEnv(t *C) {
+	dir := t.MkDir()
+	dst := filepath.Join(dir, "app.conf")
+	checkLog := filepath.Join(dir, "check.log")
+	reloadLog := filepath.Join(dir, "reload.log")
+	resourceLog := filepath.Join(dir, "resource.log")
+
+	b := Backend{Name: "mock", Keys: []string{"/"}, Onetime: true}
+	client, _ := mock.New(nil, map[string]string{"/a": "1", "/b": "2"})
+	b.ReadWatcher = client
+	res, err := NewResource([]Backend{b}, []*Renderer{{
+		Src:       s.templateFile,
+		Dst:       dst,
+		CheckCmd:  ShellCommand(fmt.Sprintf(`echo "$REMCO_RESOURCE|$REMCO_DST|$REMCO_PREVIOUS_HASH|${REMCO_CHANGED_FILES-unset}" > %s`, checkLog)),
+		ReloadCmd: Command{Argv: []string{"sh", "-c", fmt.Sprintf(`echo "$REMCO_NEW_HASH|$REMCO_BACKENDS|$REMCO_CHANGED_KEYS" > %s`, reloadLog)}},
+	}}, "app", NewExecutor("", "", "", 0, 0, nil), "", fmt.Sprintf(`echo "$REMCO_CHANGED_FILES|$REMCO_FILE_HASHES" > %s`, resourceLog))
+	t.Assert(err, IsNil)
+
+	readLog := func(name string) string {
+		data, err := ioutil.ReadFile(name)
+		t.Assert(err, IsNil)
+		return strings.TrimSuffix(string(data), "\n")
+	}
+
+	t.Assert(res.exec.SpawnChild(), IsNil)
+	defer res.exec.StopChild()
+	res.processChanges(res.backends)
+	hash, err := fileutil.Hash(dst)
+	t.Assert(err, IsNil)
+	t.Check(readLog(checkLog), Equals, "app|"+dst+"||unset")
+	t.Check(readLog(reloadLog), Equals, hash+"|mock|/a\n/b")
+	t.Check(readLog(resourceLog), Equals, dst+"|- "+hash+" "+dst)
+
+	// only the changed keys are passed
+	client.Data = map[string]string{"/a": "1", "/b": "3", "/c": "4"}
+	res.processChanges(res.backends)
+	newHash, err := fileutil.Hash(dst)
+	t.Assert(err, IsNil)
+	t.Check(readLog(checkLog), Equals, "app|"+dst+"|"+hash+"|unset")
+	t.Check(readLog(reloadLog), Equals, newHash+"|mock|/b\n/c")
+	t.Check(readLog(resourceLog), Equals, dst+"|"+hash+" "+newHash+" "+dst)
+}
+
+func (s *ResourceSuite) TestPauseResumeRender(t *C) {
+	dst := filepath.Join(t.MkDir(), "app.conf")
+	b := Backend{Name: "mock", Keys: []string{"/"}, Interval: 1}
+	client, _ := mock.New(nil, map[string]string{"/key": "1"})
+	b.ReadWatcher = client
+	res, err := NewResource([]Backend{b}, []*Renderer{{Src: s.templateFile, Dst: dst}}, "pause", NewExecutor("", "", "", 0, 0, nil), "", "")
+	t.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		res.Monitor(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+		t.Check(res.Status().State, Equals, StateStopped)
+	}()
+
+	waitFor := func(value string) bool {
+		for i := 0; i < 50; i++ {
+			if data, _ := ioutil.ReadFile(dst); strings.Contains(string(data), `"`+value+`"`) {
+				return true
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		return false
+	}
+	t.Assert(waitFor("1"), Equals, true)
+	status := res.Status()
+	t.Check(status.State, Equals, StateRunning)
+	t.Check(status.LastRender.IsZero(), Equals, false)
+	t.Check(status.Backends, DeepEquals, []BackendStatus{{Name: "mock"}})
+
+	// the changes are not rendered while the resource is paused
+	res.Pause()
+	t.Check(res.Status().State, Equals, StatePaused)
+	client.Data = map[string]string{"/key": "2"}
+	time.Sleep(1500 * time.Millisecond)
+	data, err := ioutil.ReadFile(dst)
+	t.Assert(err, IsNil)
+	t.Check(strings.Contains(string(data), `"2"`), Equals, false)
+
+	// a render request is served while paused
+	res.Render()
+	t.Check(waitFor("2"), Equals, true)
+
+	// the collected changes are rendered on resume
+	client.Data = map[string]string{"/key": "3"}
+	time.Sleep(1500 * time.Millisecond)
+	res.Resume()
+	t.Check(waitFor("3"), Equals, true)
+	t.Check(res.Status().State, Equals, StateRunning)
+
+	t.Check(res.Signal(os.Interrupt), ErrorMatches, "the resource has no child process")
+}
+
+func (s *ResourceSuite) TestPing(t *C) {
+	dst := filepath.Join(t.MkDir(), "app.conf")
+	b := Backend{Name: "mock", Keys: []string{"/"}, Interval: 1}
+	client, _ := mock.New(nil, map[string]string{"/key": "1"})
+	b.ReadWatcher = client
+	res, err := NewResource([]Backend{b}, []*Renderer{{Src: s.templateFile, Dst: dst}}, "ping", NewExecutor("", "", "", 0, 0, nil), "", "sleep 2")
+	t.Assert(err, IsNil)
+	// the loop of Monitor doesn't run yet
+	t.Check(res.Ping(10*time.Millisecond), IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		res.Monitor(ctx)
+		close(done)
+	}()
+
+	waitFor := func(cond func() bool) bool {
+		for i := 0; i < 100; i++ {
+			if cond() {
+				return true
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return false
+	}
+	t.Assert(waitFor(func() bool { return !res.Status().LastRender.IsZero() }), Equals, true)
+	t.Check(res.Ping(time.Second), IsNil)
+
+	// a slow reload command is not reported
+	client.Data = map[string]string{"/key": "2"}
+	t.Assert(waitFor(func() bool { return atomic.LoadInt32(&res.commands) > 0 }), Equals, true)
+	t.Check(res.Ping(50*time.Millisecond), IsNil)
+	t.Check(waitFor(func() bool { return atomic.LoadInt32(&res.commands) == 0 }), Equals, true)
+	t.Check(res.Ping(time.Second), IsNil)
+
+	cancel()
+	<-done
+	t.Check(res.Ping(10*time.Millisecond), IsNil)
+
+	// a loop that doesn't respond outside of the commands is reported
+	res.startHeartbeat()
+	defer res.stopHeartbeat()
+	t.Check(res.Ping(10*time.Millisecond), ErrorMatches, "the resource ping didn't respond within 10ms")
+}
+
+func (s *ResourceSuite) TestBackendStatus(t *C) {
+	res := newTransactionResource(t, &Renderer{Src: s.templateFile, Dst: filepath.Join(t.MkDir(), "app.conf")})
+	client := res.backends[0].ReadWatcher.(*mock.Client)
+
+	client.Err = fmt.Errorf("connection refused")
+	_, err := res.process(res.backends, true)
+	t.Check(err, NotNil)
+	status := res.Status()
+	t.Assert(status.Backends, HasLen, 1)
+	t.Check(status.Backends[0].Error, Matches, ".*connection refused")
+	failingSince := status.Backends[0].FailingSince
+	t.Check(failingSince.IsZero(), Equals, false)
+
+	// the time of the first error is kept
+	_, err = res.process(res.backends, true)
+	t.Check(err, NotNil)
+	t.Check(res.Status().Backends[0].FailingSince, Equals, failingSince)
+
+	client.Err = nil
+	_, err = res.process(res.backends, true)
+	t.Check(err, IsNil)
+	t.Check(res.Status().Backends[0], DeepEquals, BackendStatus{Name: "mock"})
+
+	now := time.Now()
+	res.exec.failures.add(now.Add(-time.Hour))
+	res.exec.failures.add(now)
+	t.Check(res.Failures(now.Add(-time.Minute)), Equals, 1)
+}
+
+func (s *ResourceSuite) TestFailurePolicy(t *C) {
+	t.Check(FailurePolicy{}.Validate(), HasLen, 0)
+	t.Check(FailurePolicy{OnFailure: ExitOnFailure, MaxFailures: 3, Window: "1m"}.Validate(), HasLen, 0)
+	t.Check(FailurePolicy{OnFailure: "crash", MaxFailures: -1, Window: "soon"}.Validate(), HasLen, 3)
+
+	res := newTransactionResource(t, &Renderer{Src: s.templateFile, Dst: filepath.Join(t.MkDir(), "app.conf")})
+	t.Check(res.OnFailure(), Equals, RetryOnFailure)
+	d := res.RestartDelay()
+	t.Check(d >= restartBackoffMin/2 && d <= restartBackoffMin, Equals, true, Commentf("delay %s", d))
+
+	res.failurePolicy = FailurePolicy{OnFailure: ExitOnFailure, MaxFailures: 2}
+	res.exec.failures.add(time.Now().Add(-time.Hour))
+	res.exec.failures.add(time.Now())
+	t.Check(res.FailureLimitReached(), Equals, false)
+
+	res.exec.failures.add(time.Now())
+	t.Check(res.FailureLimitReached(), Equals, true)
+	// the delay doubles with every failure in the window
+	d = res.RestartDelay()
+	t.Check(d >= restartBackoffMin && d <= 2*restartBackoffMin, Equals, true, Commentf("delay %s", d))
+
+	// retry never gives up
+	res.failurePolicy.OnFailure = RetryOnFailure
+	t.Check(res.FailureLimitReached(), Equals, false)
+}
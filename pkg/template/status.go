@@ -0,0 +1,234 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/HeavyHorst/memkv"
+)
+
+// ResourceState is the state of a resource.
+type ResourceState string
+
+const (
+	// StateStarting means that the templates weren't rendered successfully yet.
+	StateStarting ResourceState = "starting"
+	// StateRunning means that the templates were rendered and the resource watches the backends.
+	StateRunning ResourceState = "running"
+	// StatePaused means that the resource is running, but changes of the backends are not rendered.
+	StatePaused ResourceState = "paused"
+	// StateFailed means that the resource or its child process failed.
+	StateFailed ResourceState = "failed"
+	// StateStopped means that the resource was stopped.
+	StateStopped ResourceState = "stopped"
+)
+
+// BackendStatus is the connection state of a backend.
+type BackendStatus struct {
+	Name string `json:"name"`
+	// Cached is true if the backend couldn't be reached and its cached data is used.
+	Cached bool `json:"cached"`
+	// Error is the last error of the backend, it is empty after the next successful read.
+	Error string `json:"error,omitempty"`
+	// FailingSince is the time of the first error or of the switch to the cached data, it is zero
+	// while the backend can be read.
+	FailingSince time.Time `json:"failing_since,omitempty"`
+}
+
+// Status is the status of a resource.
+type Status struct {
+	State ResourceState `json:"state"`
+	// LastRender is the time of the last successful render.
+	LastRender time.Time `json:"last_render"`
+	// LastError is the last error of the resource and LastErrorTime the time it occurred.
+	LastError     string          `json:"last_error,omitempty"`
+	LastErrorTime time.Time       `json:"last_error_time"`
+	Backends      []BackendStatus `json:"backends"`
+	// PID is the process id of the child process, 0 if no child process runs.
+	PID int `json:"pid"`
+	// Restarts is the number of restarts of the child process.
+	Restarts int `json:"restarts"`
+}
+
+// Status returns the current status of the resource.
+func (t *Resource) Status() Status {
+	t.statusMutex.RLock()
+	s := t.status
+	s.Backends = append([]BackendStatus(nil), t.status.Backends...)
+	t.statusMutex.RUnlock()
+
+	if s.State == StateRunning && t.Paused() {
+		s.State = StatePaused
+	}
+	s.PID = t.exec.Pid()
+	s.Restarts = t.exec.Restarts()
+	return s
+}
+
+func (t *Resource) setState(state ResourceState) {
+	t.statusMutex.Lock()
+	defer t.statusMutex.Unlock()
+	t.status.State = state
+}
+
+// setRendered records a successful render.
+func (t *Resource) setRendered() {
+	t.statusMutex.Lock()
+	defer t.statusMutex.Unlock()
+	t.status.LastRender = time.Now()
+}
+
+// setError records the last error of the resource.
+func (t *Resource) setError(err error) {
+	t.statusMutex.Lock()
+	defer t.statusMutex.Unlock()
+	t.status.LastError = err.Error()
+	t.status.LastErrorTime = time.Now()
+}
+
+// setBackendStatus records the result of the last read of the backend name, err is nil on success.
+// cached reports whether the backend serves cached data.
+func (t *Resource) setBackendStatus(name string, cached bool, err error) {
+	t.statusMutex.Lock()
+	defer t.statusMutex.Unlock()
+	for i := range t.status.Backends {
+		b := &t.status.Backends[i]
+		if b.Name != name {
+			continue
+		}
+		b.Cached = cached
+		b.Error = ""
+		if err != nil {
+			b.Error = err.Error()
+		}
+		switch {
+		case err == nil && !cached:
+			b.FailingSince = time.Time{}
+		case b.FailingSince.IsZero():
+			b.FailingSince = time.Now()
+		}
+	}
+}
+
+// Failures returns the number of failures of the resource and its child process after since.
+// A failure is an unexpected exit or a failed liveness probe of the child process,
+// or an error that stopped the resource, e.g. a failed start_cmd.
+func (t *Resource) Failures(since time.Time) int {
+	return t.exec.failures.count(since)
+}
+
+// Data returns the current key-value pairs of all backends, merged as they are seen by the templates.
+func (t *Resource) Data() memkv.KVPairs {
+	return t.store.GetAllKVs()
+}
+
+// Render renders the templates of the running resource with the current data of all backends.
+// Paused resources are rendered as well.
+func (t *Resource) Render() {
+	select {
+	case t.renderChan <- struct{}{}:
+	default:
+		// a render is already pending
+	}
+}
+
+// Pause stops rendering the changes of the backends.
+// The changes are collected and rendered after Resume is called.
+func (t *Resource) Pause() {
+	if atomic.CompareAndSwapInt32(&t.paused, 0, 1) {
+		t.logger.Info("resource paused")
+	}
+}
+
+// Resume renders the changes that were collected while the resource was paused and
+// continues to render the changes of the backends.
+func (t *Resource) Resume() {
+	if !atomic.CompareAndSwapInt32(&t.paused, 1, 0) {
+		return
+	}
+	t.logger.Info("resource resumed")
+	select {
+	case t.resumeChan <- struct{}{}:
+	default:
+	}
+}
+
+// Ping checks that the loop of Monitor is responsive, it is blocked while the templates are rendered
+// and the commands run. It returns an error if the loop doesn't respond within timeout.
+// It returns nil if the loop doesn't run, e.g. while the resource renders the templates for the first time,
+// or if the loop runs the check and reload commands, which have their own timeouts.
+func (t *Resource) Ping(timeout time.Duration) error {
+	t.statusMutex.RLock()
+	done := t.monitorDone
+	t.statusMutex.RUnlock()
+	if done == nil {
+		return nil
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case t.pingChan <- struct{}{}:
+	case <-done:
+	case <-timer.C:
+		if atomic.LoadInt32(&t.commands) > 0 {
+			// a slow command doesn't mean that the loop hangs
+			return nil
+		}
+		return fmt.Errorf("the resource %s didn't respond within %s", t.name, timeout)
+	}
+	return nil
+}
+
+// startCommands is called when the loop of Monitor starts to run commands.
+// The returned function must be called once the commands are done.
+func (t *Resource) startCommands() func() {
+	atomic.AddInt32(&t.commands, 1)
+	return func() {
+		atomic.AddInt32(&t.commands, -1)
+	}
+}
+
+// startHeartbeat is called when the loop of Monitor starts to answer pings.
+func (t *Resource) startHeartbeat() {
+	t.statusMutex.Lock()
+	defer t.statusMutex.Unlock()
+	t.monitorDone = make(chan struct{})
+}
+
+// stopHeartbeat is called when the loop of Monitor stops to answer pings.
+func (t *Resource) stopHeartbeat() {
+	t.statusMutex.Lock()
+	defer t.statusMutex.Unlock()
+	if t.monitorDone != nil {
+		close(t.monitorDone)
+		t.monitorDone = nil
+	}
+}
+
+// Paused reports whether the resource is paused.
+func (t *Resource) Paused() bool {
+	return atomic.LoadInt32(&t.paused) == 1
+}
+
+// Signal sends the signal s to the child process of the resource.
+func (t *Resource) Signal(s os.Signal) error {
+	if t.exec.execCommand == "" {
+		return fmt.Errorf("the resource has no child process")
+	}
+	select {
+	case t.SignalChan <- s:
+		return nil
+	default:
+		return fmt.Errorf("another signal is pending")
+	}
+}
@@ -12,7 +12,9 @@
 package template
 
 import (
+	"math/rand"
 	"path"
+	"time"
 )
 
 func appendPrefix(prefix string, keys []string) []string {
@@ -22,3 +24,23 @@ func appendPrefix(prefix string, keys []string) []string {
 	}
 	return s
 }
+
+// jitter returns a random duration between d/2 and d, so that retries of multiple resources are spread out.
+func jitter(d time.Duration) time.Duration {
+	if d < 2 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// backoff returns the exponential backoff for the given attempt, starting with min and capped at max.
+func backoff(min, max time.Duration, attempt int) time.Duration {
+	d := min
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}